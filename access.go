@@ -0,0 +1,59 @@
+package adbfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// AccessChecker is implemented by DeviceClients that can check permissions faster than
+// checkAccess, e.g. by caching the results.
+type AccessChecker interface {
+	CheckAccess(name string, mode uint32, log *LogEntry) error
+}
+
+// Output of the access check command if every requested permission was granted.
+const accessGranted = "ok"
+
+// checkAccess returns ErrNoPermission if the user the client runs commands as doesn't have
+// every permission in mode (a combination of fuse.R_OK, fuse.W_OK, and fuse.X_OK) on name.
+// This is checked on the device with test, so it accounts for the user's groups, SELinux, and
+// read-only mounts, which aren't visible from the mode bits.
+func checkAccess(client DeviceClient, name string, mode uint32) error {
+	var tests []string
+	for _, flag := range []struct {
+		Mode uint32
+		Test string
+	}{
+		{fuse.R_OK, "-r"},
+		{fuse.W_OK, "-w"},
+		{fuse.X_OK, "-x"},
+	} {
+		if mode&flag.Mode != 0 {
+			tests = append(tests, fmt.Sprintf("test %s %s", flag.Test, quoteShellArg(name)))
+		}
+	}
+	if len(tests) == 0 {
+		// F_OK only checks for existence.
+		return nil
+	}
+
+	script := strings.Join(tests, " && ") + " && echo " + accessGranted
+	output, err := client.RunCommand("sh", "-c", doubleQuoteEscaper.Replace(script))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) != accessGranted {
+		return ErrNoPermission
+	}
+	return nil
+}
+
+// checkAccessWithClient checks access using client's AccessChecker implementation if it has one.
+func checkAccessWithClient(client DeviceClient, name string, mode uint32, log *LogEntry) error {
+	if checker, ok := client.(AccessChecker); ok {
+		return checker.CheckAccess(name, mode, log)
+	}
+	return checkAccess(client, name, mode)
+}
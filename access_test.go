@@ -0,0 +1,99 @@
+package adbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+)
+
+// newAccessTestClient returns a client that grants every permission in granted, and records the
+// commands it runs.
+func newAccessTestClient(granted string, commands *[]string) *delegateDeviceClient {
+	return &delegateDeviceClient{
+		stat: statFiles(&adb.DirEntry{
+			Name: "/system/file",
+			Mode: 0644,
+		}),
+		runCommand: recordCommands(commands, func(cmd string, args []string) (string, error) {
+			for _, test := range strings.Split(args[1], " && ") {
+				if strings.HasPrefix(test, "test ") && !strings.Contains(granted, test[5:7]) {
+					return "", nil
+				}
+			}
+			return "ok\r\n", nil
+		}),
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	var commands []string
+	client := newAccessTestClient("-r", &commands)
+
+	assert.NoError(t, checkAccess(client, "/system/file", fuse.F_OK))
+	assert.Empty(t, commands)
+
+	assert.NoError(t, checkAccess(client, "/system/file", fuse.R_OK))
+	assert.Equal(t, ErrNoPermission, checkAccess(client, "/system/file", fuse.R_OK|fuse.W_OK))
+	assert.Equal(t, ErrNoPermission, checkAccess(client, "/system/file", fuse.X_OK))
+	assert.Equal(t, []string{
+		"sh -c test -r '/system/file' && echo ok",
+		"sh -c test -r '/system/file' && test -w '/system/file' && echo ok",
+		"sh -c test -x '/system/file' && echo ok",
+	}, commands)
+}
+
+func TestCachingDeviceClientCheckAccess(t *testing.T) {
+	var commands []string
+	entries := NewCachedDirEntries([]*adb.DirEntry{{Name: "file"}})
+	cached := true
+	client := &CachingDeviceClient{
+		DeviceClient: newAccessTestClient("-r", &commands),
+		Cache: &delegateDirEntryCache{
+			DoGet: func(path string) (*CachedDirEntries, bool) {
+				return entries, cached
+			},
+		},
+	}
+
+	assert.Equal(t, ErrNoPermission, client.CheckAccess("/system/file", fuse.W_OK, &LogEntry{}))
+	assert.Equal(t, ErrNoPermission, client.CheckAccess("/system/file", fuse.W_OK, &LogEntry{}))
+	assert.NoError(t, client.CheckAccess("/system/file", fuse.R_OK, &LogEntry{}))
+	assert.Len(t, commands, 2)
+
+	// Results aren't cached if the directory isn't.
+	cached = false
+	assert.NoError(t, client.CheckAccess("/system/file", fuse.R_OK, &LogEntry{}))
+	assert.NoError(t, client.CheckAccess("/system/file", fuse.R_OK, &LogEntry{}))
+	assert.Len(t, commands, 4)
+}
+
+func TestAccess_Denied(t *testing.T) {
+	var commands []string
+	dev := newAccessTestClient("-r", &commands)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+	})
+	assert.NoError(t, err)
+
+	assertStatusOk(t, fs.Access("system/file", fuse.R_OK, newContext()))
+	assert.Equal(t, fuse.EACCES, fs.Access("system/file", fuse.W_OK, newContext()))
+	assert.Equal(t, fuse.ENOENT, fs.Access("system/missing", fuse.R_OK, newContext()))
+}
+
+func TestAccess_ReadOnlyFs(t *testing.T) {
+	var commands []string
+	dev := newAccessTestClient("-r-w", &commands)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+		ReadOnly:      true,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, fuse.EPERM, fs.Access("system/file", fuse.W_OK, newContext()))
+	assert.Empty(t, commands)
+}
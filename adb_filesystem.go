@@ -7,9 +7,10 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,6 +25,9 @@ import (
 // 64 symlinks ought to be deep enough for anybody.
 const MaxLinkResolveDepth = 64
 
+// How long to cache filesystem stats. Tools like df and file managers call statfs repeatedly.
+const StatFsCacheTtl = 2 * time.Second
+
 /*
 AdbFileSystem is an implementation of fuse.pathfs.FileSystem that exposes the filesystem
 on an adb device.
@@ -41,6 +45,20 @@ type AdbFileSystem struct {
 	quickUseClientPool chan DeviceClient
 
 	openFiles *OpenFiles
+
+	// DeviceRoot before it was resolved, used to check WriteRules.
+	unresolvedDeviceRoot string
+
+	// Stats of the filesystem containing each path.
+	statfsCache *controlCache
+
+	// Notifies the kernel of changes it didn't make itself. Nil until mounted.
+	notifierLock sync.Mutex
+	notifier     Notifier
+
+	// Closed by OnUnmount, after which clients aren't pooled anymore.
+	unmounted     chan struct{}
+	unmountedOnce sync.Once
 }
 
 // Config stores arguments used by AdbFileSystem.
@@ -57,17 +75,43 @@ type Config struct {
 	// Used to initially populate the device client pool, and create clients for open files.
 	ClientFactory DeviceClientFactory
 
+	// If not nil, the cache used by ClientFactory's clients, so it can be updated after the
+	// filesystem is modified.
+	Cache DirEntryCache
+
 	// Maximum number of concurrent connections for short-lived connections (does not restrict
 	// the number of concurrently open files).
 	// Values <1 are treated as 1.
 	ConnectionPoolSize int
 
 	ReadOnly bool
+
+	// If not nil, files are moved to the trash instead of being deleted.
+	Trash *Trash
+
+	// If true, paths that don't exist are matched against existing files ignoring case, so e.g.
+	// DCIM/camera opens DCIM/Camera. New files keep the case they're created with.
+	CaseInsensitive bool
+
+	// How to present symlinks that point outside DeviceRoot.
+	SymlinkPolicy SymlinkPolicy
+
+	// Restricts which paths can be modified if ReadOnly is false. Paths are checked before
+	// DeviceRoot is resolved, so rules can refer to e.g. /sdcard instead of /storage/emulated/0.
+	WriteRules WriteRules
+
+	// If not nil, the Excludes used by ClientFactory's clients. Modifications that are done by
+	// running commands, like Mkdir and Rename, don't go through those clients, so they're checked
+	// against it here.
+	Excludes *Excludes
 }
 
 type DeviceClientFactory func() DeviceClient
 
-var _ pathfs.FileSystem = &AdbFileSystem{}
+var (
+	_ pathfs.FileSystem   = &AdbFileSystem{}
+	_ notifyingFileSystem = &AdbFileSystem{}
+)
 
 func NewAdbFileSystem(config Config) (pathfs.FileSystem, error) {
 	if config.ConnectionPoolSize < 1 {
@@ -82,13 +126,17 @@ func NewAdbFileSystem(config Config) (pathfs.FileSystem, error) {
 	clientPool <- config.ClientFactory()
 
 	fs := &AdbFileSystem{
-		config:             config,
-		quickUseClientPool: clientPool,
-		openFiles: NewOpenFiles(OpenFilesOptions{
-			DeviceSerial:  config.DeviceSerial,
-			ClientFactory: config.ClientFactory,
-		}),
+		config:               config,
+		unresolvedDeviceRoot: config.DeviceRoot,
+		statfsCache:          newControlCache(StatFsCacheTtl),
+		quickUseClientPool:   clientPool,
+		unmounted:            make(chan struct{}),
 	}
+	fs.openFiles = NewOpenFiles(OpenFilesOptions{
+		DeviceSerial:     config.DeviceSerial,
+		ClientFactory:    config.ClientFactory,
+		FileSavedHandler: fs.fileSaved,
+	})
 	if err := fs.initialize(); err != nil {
 		return nil, err
 	}
@@ -118,13 +166,13 @@ func (fs *AdbFileSystem) initialize() error {
 	return nil
 }
 
-func readLinkRecursively(device DeviceClient, path string, logEntry *LogEntry) (string, *adb.DirEntry, error) {
+func readLinkRecursively(device DeviceClient, name string, logEntry *LogEntry) (string, *adb.DirEntry, error) {
 	var result bytes.Buffer
 	currentDepth := 0
 
-	fmt.Fprintf(&result, "attempting to resolve %s if it's a symlink\n", path)
+	fmt.Fprintf(&result, "attempting to resolve %s if it's a symlink\n", name)
 
-	entry, err := device.Stat(path, logEntry)
+	entry, err := device.Stat(name, logEntry)
 	if err != nil {
 		return "", nil, err
 	}
@@ -135,20 +183,24 @@ func readLinkRecursively(device DeviceClient, path string, logEntry *LogEntry) (
 		}
 		currentDepth++
 
-		fmt.Fprintln(&result, path)
-		path, err = readLink(device, path)
+		fmt.Fprintln(&result, name)
+		linkDir := path.Dir(name)
+		name, err = readLink(device, name, logEntry)
 		if err != nil {
 			return "", nil, util.WrapErrf(err, "reading link: %s", result.String())
 		}
+		if !strings.HasPrefix(name, "/") {
+			name = path.Join(linkDir, name)
+		}
 
-		fmt.Fprintln(&result, " ➜", path)
-		entry, err = device.Stat(path, logEntry)
+		fmt.Fprintln(&result, " ➜", name)
+		entry, err = device.Stat(name, logEntry)
 		if err != nil {
-			return "", nil, util.WrapErrf(err, "stating %s: %s", path, result.String())
+			return "", nil, util.WrapErrf(err, "stating %s: %s", name, result.String())
 		}
 	}
 
-	return path, entry, nil
+	return name, entry, nil
 }
 
 func (fs *AdbFileSystem) String() string {
@@ -169,19 +221,42 @@ func (fs *AdbFileSystem) StatFs(name string) *fuse.StatfsOut {
 		return nil
 	}
 
-	output, err := device.RunCommand("stat", "-f", name)
+	// Paths are cached separately since they may be on different volumes, e.g. /storage/XXXX-XXXX.
+	value, err := fs.statfsCache.GetOrLoad(name, func() (interface{}, error) {
+		return statfs(device, name)
+	})
 	if err != nil {
-		logEntry.ErrorMsg(err, "running statfs command")
+		logEntry.ErrorMsg(err, "getting filesystem stats")
 		return nil
 	}
 
-	statfs, err := parseStatfs(output)
+	statfs := *value.(*fuse.StatfsOut)
+	logEntry.Result("%+v", statfs)
+	return &statfs
+}
+
+// statfs returns the stats of the filesystem containing name. It uses stat -f if the device
+// supports it, and df otherwise.
+func statfs(client DeviceClient, name string) (*fuse.StatfsOut, error) {
+	output, err := client.RunCommand("stat", "-f", name)
+	if err != nil {
+		return nil, err
+	}
+	stat, statErr := parseStatfs(output)
+	if statErr == nil {
+		return stat, nil
+	}
+	cli.Log.Debugf("stat -f failed, falling back to df: %v", statErr)
+
+	output, err = client.RunCommand("df", name)
 	if err != nil {
-		logEntry.ErrorMsg(err, "invalid stat command output:%v\n%s", err, output)
-		return nil
+		return nil, err
 	}
-	logEntry.Result("%+v", *statfs)
-	return statfs
+	stat, err = parseDf(output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stat -f output (%v) and df output (%v):\n%s", statErr, err, output)
+	}
+	return stat, nil
 }
 
 func parseStatfs(output string) (stat *fuse.StatfsOut, err error) {
@@ -222,16 +297,21 @@ func parseStatfs(output string) (stat *fuse.StatfsOut, err error) {
 		intVal, err := strconv.Atoi(value)
 		// Don't return err immediately, we don't always need to parse an int.
 
-		switch key {
-		case "Namelen":
+		// Toybox capitalizes keys differently.
+		switch strings.ToLower(key) {
+		case "namelen":
 			if err == nil {
 				stat.NameLen = uint32(intVal)
 			}
-		case "Blocksize":
+		case "blocksize":
 			if err == nil {
 				stat.Bsize = uint32(intVal)
 			}
-		case "Total":
+		case "fundamentalblocksize":
+			if err == nil {
+				stat.Frsize = uint32(intVal)
+			}
+		case "total":
 			if err == nil {
 				switch scope {
 				case "Blocks":
@@ -240,7 +320,7 @@ func parseStatfs(output string) (stat *fuse.StatfsOut, err error) {
 					stat.Files = uint64(intVal)
 				}
 			}
-		case "Free":
+		case "free":
 			if err == nil {
 				switch scope {
 				case "Blocks":
@@ -249,7 +329,7 @@ func parseStatfs(output string) (stat *fuse.StatfsOut, err error) {
 					stat.Ffree = uint64(intVal)
 				}
 			}
-		case "Available":
+		case "available":
 			if err == nil {
 				switch scope {
 				case "Blocks":
@@ -271,9 +351,125 @@ func parseStatfs(output string) (stat *fuse.StatfsOut, err error) {
 		return nil, err
 	}
 
+	if stat.Bsize == 0 {
+		// E.g. the device's stat doesn't support -f, and printed an error.
+		return nil, errors.New("no block size")
+	}
+	if stat.Frsize == 0 {
+		// Block counts are in units of the fragment size, which is usually the block size.
+		stat.Frsize = stat.Bsize
+	}
 	return stat, nil
 }
 
+var dfBlocksHeader = regexp.MustCompile(`^([0-9]+)([kK]?)-blocks$`)
+
+// parseDf parses the output of df for a single path. The formats of toolbox, which prints
+// human-readable sizes and the block size, and toybox and busybox, which print counts of
+// fixed-size blocks, are supported.
+func parseDf(output string) (*fuse.StatfsOut, error) {
+	lines := strings.Split(strings.TrimSpace(strings.Replace(output, "\r", "", -1)), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("expected header and values, got %q", output)
+	}
+	header := strings.Fields(lines[0])
+	// Busybox wraps long filesystem names onto their own line.
+	values := strings.Fields(strings.Join(lines[1:], " "))
+	if len(header) < 2 || header[0] != "Filesystem" {
+		return nil, fmt.Errorf("invalid header: %s", lines[0])
+	}
+
+	/*
+		Sample outputs:
+
+		Filesystem               Size     Used     Free   Blksize
+		/sdcard                 12.0G     2.5G     9.5G   4096
+
+		Filesystem     1K-blocks    Used Available Use% Mounted on
+		/data/media     25667168 9813468  15722628  39% /storage/emulated
+	*/
+
+	if header[1] == "Size" {
+		if len(values) < 5 {
+			return nil, fmt.Errorf("expected 5 values, got %q", values)
+		}
+		blockSize, err := strconv.ParseUint(values[4], 10, 32)
+		if err != nil || blockSize == 0 {
+			return nil, fmt.Errorf("invalid block size: %s", values[4])
+		}
+		var sizes [3]uint64
+		for i := range sizes {
+			if sizes[i], err = parseHumanSize(values[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return &fuse.StatfsOut{
+			Bsize:  uint32(blockSize),
+			Frsize: uint32(blockSize),
+			Blocks: sizes[0] / blockSize,
+			Bfree:  sizes[2] / blockSize,
+			Bavail: sizes[2] / blockSize,
+		}, nil
+	}
+
+	match := dfBlocksHeader.FindStringSubmatch(header[1])
+	if match == nil {
+		return nil, fmt.Errorf("unknown block size: %s", header[1])
+	}
+	blockSize, _ := strconv.ParseUint(match[1], 10, 32)
+	if match[2] != "" {
+		blockSize *= 1024
+	}
+	if len(values) < 4 {
+		return nil, fmt.Errorf("expected at least 4 values, got %q", values)
+	}
+	var counts [3]uint64
+	for i := range counts {
+		var err error
+		if counts[i], err = strconv.ParseUint(values[i+1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", header[i+1], values[i+1])
+		}
+	}
+	total, used, available := counts[0], counts[1], counts[2]
+	free := available
+	if used <= total {
+		// Free includes blocks reserved for root, which aren't available.
+		free = total - used
+	}
+	return &fuse.StatfsOut{
+		Bsize:  uint32(blockSize),
+		Frsize: uint32(blockSize),
+		Blocks: total,
+		Bfree:  free,
+		Bavail: available,
+	}, nil
+}
+
+// parseHumanSize parses a size printed by toolbox df, e.g. 12.5G, into bytes.
+func parseHumanSize(size string) (uint64, error) {
+	multiplier := 1.0
+	if size != "" {
+		switch size[len(size)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return uint64(value * multiplier), nil
+}
+
 func (fs *AdbFileSystem) GetAttr(name string, _ *fuse.Context) (attr *fuse.Attr, status fuse.Status) {
 	name = fs.convertClientPathToDevicePath(name)
 
@@ -284,9 +480,23 @@ func (fs *AdbFileSystem) GetAttr(name string, _ *fuse.Context) (attr *fuse.Attr,
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+
+	entry, err := device.Stat(name, logEntry)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+	if entry = fs.applySymlinkPolicy(device, name, entry, logEntry); entry == nil {
+		return nil, toFuseStatusLog(util.Errorf(util.FileNoExistError, "%s escapes the device root", name), logEntry)
+	}
+
 	attr = new(fuse.Attr)
-	err := getAttr(name, device, logEntry, attr)
-	return attr, toFuseStatusLog(err, logEntry)
+	asFuseAttr(entry, attr)
+	logEntry.Result("entry=%v, attr=%v", entry, attr)
+	return attr, toFuseStatusLog(OK, logEntry)
 }
 
 // getAttr performs the actual stat call on a client, converts errors to status, and converts
@@ -311,11 +521,26 @@ func (fs *AdbFileSystem) OpenDir(name string, _ *fuse.Context) ([]fuse.DirEntry,
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+
 	entries, err := device.ListDirEntries(name, logEntry)
 	if err != nil {
 		return nil, toFuseStatusLog(err, logEntry)
 	}
 
+	if fs.config.SymlinkPolicy != SymlinkRewrite {
+		presented := make([]*adb.DirEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry = fs.applySymlinkPolicy(device, path.Join(name, entry.Name), entry, logEntry); entry != nil {
+				presented = append(presented, entry)
+			}
+		}
+		entries = presented
+	}
+
 	result := asFuseDirEntries(entries)
 	return result, toFuseStatusLog(OK, logEntry)
 }
@@ -329,31 +554,50 @@ func (fs *AdbFileSystem) Readlink(name string, context *fuse.Context) (target st
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
-	target, err := readLink(device, name)
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return "", toFuseStatusLog(err, logEntry)
+	}
+
+	target, err = readLink(device, name, logEntry)
 	if err == nil {
 		// Translate absolute links as relative to this mountpoint.
-		// Don't use path.Abs since we don't want to have platform-specific behavior.
-		if strings.HasPrefix(target, "/") {
-			target = filepath.Join(fs.config.Mountpoint, target)
-		}
-
+		target, err = fs.translateLinkTarget(device, name, target, logEntry)
+	}
+	if err == nil {
 		logEntry.Result("%s", target)
 	}
 
 	return target, toFuseStatusLog(err, logEntry)
 }
 
-func readLink(client DeviceClient, path string) (string, error) {
+// LinkReader is implemented by DeviceClients that can read links faster than runReadlink, e.g. by
+// caching the results.
+type LinkReader interface {
+	ReadLink(name string, log *LogEntry) (string, error)
+}
+
+// readLink returns the target of the link at path, using client's LinkReader implementation if it
+// has one.
+func readLink(client DeviceClient, path string, logEntry *LogEntry) (string, error) {
+	if reader, ok := client.(LinkReader); ok {
+		return reader.ReadLink(path, logEntry)
+	}
+	return runReadlink(client, path, logEntry)
+}
+
+func runReadlink(client DeviceClient, path string, logEntry *LogEntry) (string, error) {
 	// The sync protocol doesn't provide a way to read links.
 	// Some versions of Android have a readlink command that supports resolving recursively, but
 	// others (notably Marshmallow) don't, so don't try to do anything fancy (see issue #14).
 	// OSX Finder won't follow recursive symlinks in tree view, but it should resolve them if you
 	// open them.
-	result, err := client.RunCommand("readlink", path)
+	result, err := client.RunScheduledCommand(MetadataOperation, logEntry, "readlink", path)
 	if err != nil {
 		return "", err
 	}
-	result = strings.TrimSuffix(result, "\r\n")
+	// Devices that don't run commands in a pty only print \n.
+	result = strings.TrimRight(result, "\r\n")
 
 	if result == ReadlinkInvalidArgument {
 		return "", ErrNotALink
@@ -370,22 +614,30 @@ func (fs *AdbFileSystem) Access(name string, mode uint32, context *fuse.Context)
 	logEntry := StartOperation("Access", name)
 	defer logEntry.SuppressFinishOperation()
 
-	if mode&fuse.W_OK == fuse.W_OK && fs.config.ReadOnly {
-		// This is not a user-permission denial, it's a filesystem config denial, so don't use EACCES.
-		return toFuseStatusLog(ErrNotPermitted, logEntry)
-	}
-
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	if mode&fuse.W_OK == fuse.W_OK {
+		if err := fs.checkWritable(name, logEntry); err != nil {
+			return toFuseStatusLog(err, logEntry)
+		}
+	}
+
 	// Access is required to resolve symlinks.
-	name, _, err := readLinkRecursively(device, name, logEntry)
+	name, _, err = readLinkRecursively(device, name, logEntry)
 	if err != nil {
 		return toFuseStatusLog(err, logEntry)
 	}
 
-	// For now, just assume all existing files are accessible.
-	logEntry.Result("target %s exists, assuming all access permitted", name)
+	if err := checkAccessWithClient(device, name, mode, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	logEntry.Result("target %s is accessible", name)
 	return toFuseStatusLog(OK, logEntry)
 }
 
@@ -402,6 +654,14 @@ func (fs *AdbFileSystem) Create(name string, rawFlags uint32, perms uint32, cont
 		flags |= O_WRONLY
 	}
 
+	name, err := fs.resolveParentCase(name, logEntry)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+
+	// The kernel looks the file up right after creating it.
+	fs.invalidatePaths(name)
+
 	file, err := fs.createFile(name, flags, os.FileMode(perms), logEntry)
 	if err == nil {
 		logEntry.Result("%s", file)
@@ -416,6 +676,16 @@ func (fs *AdbFileSystem) Open(name string, flags uint32, context *fuse.Context)
 	logEntry := StartOperation("Open", name)
 	defer logEntry.FinishOperation()
 
+	if fs.config.CaseInsensitive {
+		device := fs.getQuickUseClient()
+		var err error
+		name, err = fs.resolveCase(device, name, logEntry)
+		fs.recycleQuickUseClient(device)
+		if err != nil {
+			return nil, toFuseStatusLog(err, logEntry)
+		}
+	}
+
 	file, err := fs.createFile(name, FileOpenFlags(flags), DontSetPerms, logEntry)
 	if err == nil {
 		logEntry.Result("%s", file)
@@ -425,9 +695,10 @@ func (fs *AdbFileSystem) Open(name string, flags uint32, context *fuse.Context)
 
 func (fs *AdbFileSystem) createFile(name string, flags FileOpenFlags, perms os.FileMode, logEntry *LogEntry) (nodefs.File, error) {
 	isWriteOp := flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND)
-	if isWriteOp && fs.config.ReadOnly {
-		// This is not a user-permission denial, it's a filesystem config denial, so don't use EACCES.
-		return nil, ErrNotPermitted
+	if isWriteOp {
+		if err := fs.checkWritable(name, logEntry); err != nil {
+			return nil, err
+		}
 	}
 	cli.Log.Debugf("createFile: flags=%s, ReadOnly=%t", flags, fs.config.ReadOnly)
 
@@ -450,14 +721,22 @@ func (fs *AdbFileSystem) Mkdir(name string, perms uint32, context *fuse.Context)
 	logEntry := StartOperation("Mkdir", name)
 	defer logEntry.FinishOperation()
 
-	if fs.config.ReadOnly {
-		return toFuseStatusLog(ErrNotPermitted, logEntry)
-	}
-
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
-	err := mkdir(device, name)
+	name, err := fs.resolveParentCaseWithClient(device, name, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkExcluded(device, name); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkWritable(name, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	err = mkdir(device, name)
+	fs.invalidatePaths(name)
 	return toFuseStatusLog(err, logEntry)
 }
 
@@ -482,14 +761,31 @@ func (fs *AdbFileSystem) Rename(oldName, newName string, context *fuse.Context)
 	logEntry := StartOperation("Rename", fmt.Sprintf("%s→%s", oldName, newName))
 	defer logEntry.FinishOperation()
 
-	if fs.config.ReadOnly {
-		return toFuseStatusLog(ErrNotPermitted, logEntry)
-	}
-
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
-	err := rename(device, oldName, newName)
+	oldName, err := fs.resolveCase(device, oldName, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	// The new name keeps its case, it only has to be in the right directory.
+	newName, err = fs.resolveParentCaseWithClient(device, newName, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	if err := fs.checkExcluded(device, oldName, newName); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkWritable(oldName, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkWritable(newName, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	err = rename(device, oldName, newName)
+	fs.invalidatePaths(oldName, newName)
 	return toFuseStatusLog(err, logEntry)
 }
 
@@ -508,19 +804,36 @@ func rename(client DeviceClient, oldName, newName string) error {
 }
 
 func (fs *AdbFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	relName := name
 	name = fs.convertClientPathToDevicePath(name)
 
 	logEntry := StartOperation("Rename", name)
 	defer logEntry.FinishOperation()
 
-	if fs.config.ReadOnly {
-		return toFuseStatusLog(ErrNotPermitted, logEntry)
-	}
-
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
-	err := rmdir(device, name)
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkExcluded(device, name); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkWritable(name, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	if fs.config.Trash != nil && !fs.config.Trash.Contains(relName) {
+		// Trashed files keep their original path, so trash directories too, or rm -r would leave no
+		// record of them.
+		logEntry.Result("moving to trash")
+		err = fs.config.Trash.MoveDir(device, name, relName)
+		fs.invalidatePaths(fs.config.Trash.Dir())
+	} else {
+		err = rmdir(device, name)
+	}
+	fs.invalidatePaths(name)
 	return toFuseStatusLog(err, logEntry)
 }
 
@@ -539,19 +852,35 @@ func rmdir(client DeviceClient, name string) error {
 }
 
 func (fs *AdbFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	relName := name
 	name = fs.convertClientPathToDevicePath(name)
 
 	logEntry := StartOperation("Unlink", name)
 	defer logEntry.FinishOperation()
 
-	if fs.config.ReadOnly {
-		return toFuseStatusLog(ErrNotPermitted, logEntry)
-	}
-
 	device := fs.getQuickUseClient()
 	defer fs.recycleQuickUseClient(device)
 
-	err := unlink(device, name)
+	name, err := fs.resolveCase(device, name, logEntry)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkExcluded(device, name); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if err := fs.checkWritable(name, logEntry); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	if fs.config.Trash != nil && !fs.config.Trash.Contains(relName) {
+		// Deleting files that are already in the trash removes them permanently.
+		logEntry.Result("moving to trash")
+		err = fs.config.Trash.Move(device, name, relName)
+		fs.invalidatePaths(fs.config.Trash.Dir())
+	} else {
+		err = unlink(device, name)
+	}
+	fs.invalidatePaths(name)
 	return toFuseStatusLog(err, logEntry)
 }
 
@@ -649,9 +978,36 @@ func (fs *AdbFileSystem) Utimens(name string, Atime *time.Time, Mtime *time.Time
 }
 
 func (fs *AdbFileSystem) OnMount(nodeFs *pathfs.PathNodeFs) {
+	fs.setNotifier(nodeFs)
+}
+
+func (fs *AdbFileSystem) setNotifier(notifier Notifier) {
+	fs.notifierLock.Lock()
+	defer fs.notifierLock.Unlock()
+	fs.notifier = notifier
 }
 
+// OnUnmount releases everything held for the device: open file buffers, pooled clients, and
+// cached entries. Operations that are still running, or on files that are still open, talk to
+// the device directly.
 func (fs *AdbFileSystem) OnUnmount() {
+	fs.unmountedOnce.Do(func() {
+		close(fs.unmounted)
+		fs.setNotifier(nil)
+		fs.openFiles.Close()
+		fs.statfsCache.Clear()
+		if fs.config.Cache != nil {
+			fs.config.Cache.Clear()
+		}
+
+		for {
+			select {
+			case <-fs.quickUseClientPool:
+			default:
+				return
+			}
+		}
+	})
 }
 
 func (fs *AdbFileSystem) SetDebug(debug bool) {
@@ -664,11 +1020,179 @@ func (fs *AdbFileSystem) getNewClient() (client DeviceClient) {
 }
 
 func (fs *AdbFileSystem) getQuickUseClient() DeviceClient {
-	return <-fs.quickUseClientPool
+	select {
+	case client := <-fs.quickUseClientPool:
+		return client
+	case <-fs.unmounted:
+		return fs.getNewClient()
+	}
 }
 
 func (fs *AdbFileSystem) recycleQuickUseClient(client DeviceClient) {
-	fs.quickUseClientPool <- client
+	select {
+	case <-fs.unmounted:
+	default:
+		fs.quickUseClientPool <- client
+	}
+}
+
+// checkExcluded returns FileNoExistError if any of names, paths on the device, are excluded.
+func (fs *AdbFileSystem) checkExcluded(client DeviceClient, names ...string) error {
+	if fs.config.Excludes == nil {
+		return nil
+	}
+	for _, name := range names {
+		if fs.config.Excludes.Match(client, name) {
+			return util.Errorf(util.FileNoExistError, "%s is excluded", name)
+		}
+	}
+	return nil
+}
+
+// checkWritable returns an error if name, a path on the device, can't be modified, either because
+// the filesystem is read-only or because of the write rules.
+func (fs *AdbFileSystem) checkWritable(name string, logEntry *LogEntry) error {
+	if fs.config.ReadOnly {
+		// This is not a user-permission denial, it's a filesystem config denial, so don't use EACCES.
+		return ErrNotPermitted
+	}
+
+	rulesPath := path.Join("/", fs.unresolvedDeviceRoot,
+		strings.TrimPrefix(name, path.Join("/", fs.config.DeviceRoot)))
+	if reason, err := fs.config.WriteRules.Check(rulesPath); err != nil {
+		logEntry.ErrorMsg(err, "write to %s %s", rulesPath, reason)
+		return err
+	}
+	return nil
+}
+
+// resolveCase returns name, a path on the device, with every component replaced by the existing
+// file that matches it ignoring case, if CaseInsensitive is set and name doesn't exist.
+// Components that don't match anything are left as-is. Returns ErrAmbiguousName if a component
+// matches more than one file.
+func (fs *AdbFileSystem) resolveCase(client DeviceClient, name string, logEntry *LogEntry) (string, error) {
+	if !fs.config.CaseInsensitive {
+		return name, nil
+	}
+	if _, err := client.Stat(name, logEntry); !util.HasErrCode(err, util.FileNoExistError) {
+		// The common case: the name is already correct.
+		return name, nil
+	}
+
+	root := path.Join("/", fs.config.DeviceRoot)
+	resolved := root
+	components := splitPath(strings.TrimPrefix(name, root))
+	for i, component := range components {
+		entries, err := client.ListDirEntries(resolved, logEntry)
+		if err != nil {
+			// The parent doesn't exist or can't be listed, so nothing below it can match either.
+			return path.Join(append([]string{resolved}, components[i:]...)...), nil
+		}
+
+		matches := NewCachedDirEntries(entries).FindFold(component)
+		switch len(matches) {
+		case 0:
+			return path.Join(append([]string{resolved}, components[i:]...)...), nil
+		case 1:
+			resolved = path.Join(resolved, matches[0].Name)
+		default:
+			names := make([]string, len(matches))
+			for j, match := range matches {
+				names[j] = match.Name
+			}
+			cli.Log.Warnf("%s is ambiguous in %s, matches: %s", component, resolved, strings.Join(names, ", "))
+			return "", ErrAmbiguousName
+		}
+	}
+
+	cli.Log.Debugf("resolved %s ➜ %s ignoring case", name, resolved)
+	return resolved, nil
+}
+
+// resolveParentCase is like resolveCase, but only resolves the directory containing name, so
+// newly-created files keep the case they were given.
+func (fs *AdbFileSystem) resolveParentCase(name string, logEntry *LogEntry) (string, error) {
+	if !fs.config.CaseInsensitive {
+		return name, nil
+	}
+
+	device := fs.getQuickUseClient()
+	defer fs.recycleQuickUseClient(device)
+	return fs.resolveParentCaseWithClient(device, name, logEntry)
+}
+
+func (fs *AdbFileSystem) resolveParentCaseWithClient(client DeviceClient, name string, logEntry *LogEntry) (string, error) {
+	dir, base := path.Split(name)
+	if dir == "/" || dir == path.Join("/", fs.config.DeviceRoot)+"/" {
+		return name, nil
+	}
+
+	dir, err := fs.resolveCase(client, path.Clean(dir), logEntry)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, base), nil
+}
+
+/*
+invalidatePaths removes everything the cache and the kernel know about names, their parent
+directories, and anything under them, so a lookup after a modification sees it. It's called even if
+the modification failed, since it may have partially succeeded.
+*/
+func (fs *AdbFileSystem) invalidatePaths(names ...string) {
+	for _, name := range names {
+		if rel, ok := fs.mountRelativePath(name); ok && rel != "" {
+			fs.notify(func(notifier Notifier) {
+				notifyEntryChanged(notifier, rel)
+			})
+		}
+	}
+
+	if fs.config.Cache == nil {
+		return
+	}
+	for _, name := range names {
+		fs.config.Cache.Remove(path.Dir(name))
+		fs.config.Cache.RemoveTree(name)
+		fs.config.Cache.RemoveMissing(name)
+	}
+}
+
+// fileSaved tells the kernel that the contents and attributes of name changed on the device.
+func (fs *AdbFileSystem) fileSaved(name string) {
+	if rel, ok := fs.mountRelativePath(name); ok && rel != "" {
+		fs.notify(func(notifier Notifier) {
+			logNotifyStatus(notifier.FileNotify(rel, 0, 0), "FileNotify", rel)
+			notifyEntryChanged(notifier, rel)
+		})
+	}
+}
+
+// notify calls f with the Notifier if the filesystem is mounted. f is called asynchronously,
+// since the kernel may not process notifications until the current operation returns.
+func (fs *AdbFileSystem) notify(f func(notifier Notifier)) {
+	fs.notifierLock.Lock()
+	notifier := fs.notifier
+	fs.notifierLock.Unlock()
+
+	if notifier != nil {
+		go f(notifier)
+	}
+}
+
+// notifyEntryChanged makes the kernel look up name again, and reload its directory's listing.
+func notifyEntryChanged(notifier Notifier, name string) {
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	logNotifyStatus(notifier.EntryNotify(dir, base), "EntryNotify", name)
+	logNotifyStatus(notifier.FileNotify(dir, 0, 0), "FileNotify", dir)
+}
+
+func logNotifyStatus(status fuse.Status, op, name string) {
+	// ENOENT just means the kernel doesn't have the path cached.
+	if !status.Ok() && status != fuse.ENOENT {
+		cli.Log.Debugf("%s(%s): %s", op, name, status)
+	}
 }
 
 func (fs *AdbFileSystem) convertClientPathToDevicePath(name string) string {
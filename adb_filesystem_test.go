@@ -3,11 +3,17 @@ package adbfs
 import (
 	"fmt"
 	"os"
+	"path"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
 )
 
 func TestGetAttr_Root(t *testing.T) {
@@ -181,6 +187,40 @@ func TestGetAttr_RegularFile(t *testing.T) {
 	assert.Equal(t, uint32(0444), attr.Mode&uint32(os.ModePerm))
 }
 
+func TestOnUnmount_StopsPoolingClients(t *testing.T) {
+	var clients int
+	cache := NewDirEntryCache(time.Minute, 0)
+	cache.GetOrLoad("/", func(path string) (*CachedDirEntries, error) {
+		return &CachedDirEntries{}, nil
+	})
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint: "",
+		Cache:      cache,
+		ClientFactory: func() DeviceClient {
+			clients++
+			return &delegateDeviceClient{
+				stat: statFiles(&adb.DirEntry{
+					Name: "/version.txt",
+					Mode: 0444,
+				}),
+			}
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, clients)
+
+	fs.OnUnmount()
+	_, found := cache.Get("/")
+	assert.False(t, found)
+
+	// Operations still work, but each one gets its own client.
+	_, status := fs.GetAttr("version.txt", newContext())
+	assertStatusOk(t, status)
+	_, status = fs.GetAttr("version.txt", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, 3, clients)
+}
+
 func TestReadLink_AbsoluteTarget(t *testing.T) {
 	dev := &delegateDeviceClient{
 		runCommand: func(cmd string, args []string) (string, error) {
@@ -301,6 +341,304 @@ func TestMkdir_ReadOnlyFs(t *testing.T) {
 	assert.Equal(t, fuse.EPERM, status)
 }
 
+func TestMkdir_WriteRules(t *testing.T) {
+	var commands []string
+	dev := &delegateDeviceClient{
+		stat: statFiles(&adb.DirEntry{
+			Name: "/sdcard",
+			Mode: os.ModeSymlink,
+		}, &adb.DirEntry{
+			Name: "/storage/emulated/0",
+			Mode: os.ModeDir,
+		}),
+		runCommand: func(cmd string, args []string) (string, error) {
+			if cmd == "readlink" {
+				return "/storage/emulated/0", nil
+			}
+			commands = append(commands, cmd+" "+strings.Join(args, " "))
+			return "", nil
+		},
+	}
+	rules, err := NewWriteRules([]string{"/sdcard/Download"}, []string{"/sdcard/Download/keep"})
+	assert.NoError(t, err)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+		DeviceRoot:    "/sdcard",
+		WriteRules:    rules,
+	})
+	assert.NoError(t, err)
+
+	assertStatusOk(t, fs.Mkdir("Download/newdir", 0, newContext()))
+	assert.Equal(t, fuse.Status(syscall.EROFS), fs.Mkdir("DCIM/newdir", 0, newContext()))
+	assert.Equal(t, fuse.Status(syscall.EROFS), fs.Mkdir("Download/keep/newdir", 0, newContext()))
+	assert.Equal(t, []string{"mkdir /storage/emulated/0/Download/newdir"}, commands)
+}
+
+func newCaseInsensitiveTestFileSystem(t *testing.T, commands *[]string) *AdbFileSystem {
+	files := map[string][]*adb.DirEntry{
+		"/":            {{Name: "DCIM", Mode: os.ModeDir}},
+		"/DCIM":        {{Name: "Camera", Mode: os.ModeDir}, {Name: "a.jpg"}, {Name: "A.JPG"}},
+		"/DCIM/Camera": {{Name: "Photo.jpg"}},
+	}
+	dev := &delegateDeviceClient{
+		stat: func(name string) (*adb.DirEntry, error) {
+			for _, entry := range files[path.Dir(name)] {
+				if entry.Name == path.Base(name) {
+					return entry, nil
+				}
+			}
+			return nil, util.Errorf(util.FileNoExistError, "%s", name)
+		},
+		listDirEntries: func(name string) ([]*adb.DirEntry, error) {
+			if entries, ok := files[name]; ok {
+				return entries, nil
+			}
+			return nil, util.Errorf(util.FileNoExistError, "%s", name)
+		},
+		runCommand: recordCommands(commands, nil),
+	}
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:      "",
+		ClientFactory:   func() DeviceClient { return dev },
+		CaseInsensitive: true,
+	})
+	assert.NoError(t, err)
+	return fs.(*AdbFileSystem)
+}
+
+func TestGetAttr_CaseInsensitive(t *testing.T) {
+	var commands []string
+	fs := newCaseInsensitiveTestFileSystem(t, &commands)
+
+	_, status := fs.GetAttr("dcim/camera/photo.JPG", newContext())
+	assertStatusOk(t, status)
+	_, status = fs.GetAttr("dcim/video", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+	_, status = fs.GetAttr("dcim/a.jpg", newContext())
+	assertStatusOk(t, status)
+	_, status = fs.GetAttr("dcim/a.Jpg", newContext())
+	assert.Equal(t, fuse.EINVAL, status)
+}
+
+func TestMkdir_CaseInsensitive(t *testing.T) {
+	var commands []string
+	fs := newCaseInsensitiveTestFileSystem(t, &commands)
+
+	assertStatusOk(t, fs.Mkdir("dcim/camera/NewDir", 0, newContext()))
+	assertStatusOk(t, fs.Rename("dcim/camera/photo.jpg", "dcim/Renamed.JPG", newContext()))
+	assert.Equal(t, []string{
+		"mkdir /DCIM/Camera/NewDir",
+		"mv /DCIM/Camera/Photo.jpg /DCIM/Renamed.JPG",
+	}, commands)
+}
+
+func TestMkdir_ForgetsMissing(t *testing.T) {
+	exists := false
+	dev := &delegateDeviceClient{
+		stat: func(path string) (*adb.DirEntry, error) {
+			if path == "/newdir" && exists {
+				return &adb.DirEntry{Name: "newdir", Mode: os.ModeDir}, nil
+			}
+			return nil, util.Errorf(util.FileNoExistError, "%s", path)
+		},
+		runCommand: func(cmd string, args []string) (string, error) {
+			if cmd == "mkdir" && args[0] == "/newdir" {
+				exists = true
+				return "", nil
+			}
+			t.Fatal("invalid command:", cmd, args)
+			return "", nil
+		},
+	}
+	cache := NewDirEntryCache(time.Minute, time.Minute)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: NewCachingDeviceClientFactory(cache, func() DeviceClient { return dev }),
+		Cache:         cache,
+	})
+	assert.NoError(t, err)
+
+	_, status := fs.GetAttr("newdir", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+	assertStatusOk(t, fs.Mkdir("newdir", 0, newContext()))
+	attr, status := fs.GetAttr("newdir", newContext())
+	assertStatusOk(t, status)
+	assert.True(t, attr.IsDir())
+}
+
+// newMemoryDevice returns a client for a device whose filesystem is modes, a map of absolute paths
+// to file modes, which is modified by mkdir, mv, rmdir, and rm.
+func newMemoryDevice(modes map[string]os.FileMode) *delegateDeviceClient {
+	return &delegateDeviceClient{
+		stat: func(name string) (*adb.DirEntry, error) {
+			if mode, found := modes[name]; found || name == "/" {
+				return &adb.DirEntry{Name: path.Base(name), Mode: mode}, nil
+			}
+			return nil, util.Errorf(util.FileNoExistError, "%s", name)
+		},
+		listDirEntries: func(dir string) (entries []*adb.DirEntry, err error) {
+			for name, mode := range modes {
+				if path.Dir(name) == dir {
+					entries = append(entries, &adb.DirEntry{Name: path.Base(name), Mode: mode})
+				}
+			}
+			return
+		},
+		runCommand: func(cmd string, args []string) (string, error) {
+			name := args[len(args)-1]
+			switch cmd {
+			case "mkdir":
+				modes[name] = os.ModeDir
+			case "mv":
+				for oldName, mode := range modes {
+					if oldName == args[0] || strings.HasPrefix(oldName, args[0]+"/") {
+						delete(modes, oldName)
+						modes[name+strings.TrimPrefix(oldName, args[0])] = mode
+					}
+				}
+			case "rmdir", "rm":
+				delete(modes, name)
+			}
+			return "", nil
+		},
+	}
+}
+
+func TestModifications_ReadAfterWrite(t *testing.T) {
+	for _, test := range []struct {
+		Op       string
+		Modify   func(fs pathfs.FileSystem) fuse.Status
+		Listings map[string][]string
+		Missing  []string
+	}{
+		{
+			Op: "Mkdir",
+			Modify: func(fs pathfs.FileSystem) fuse.Status {
+				return fs.Mkdir("a/new", 0, newContext())
+			},
+			Listings: map[string][]string{
+				"a": {"b", "file", "new"},
+			},
+		},
+		{
+			Op: "Rename",
+			Modify: func(fs pathfs.FileSystem) fuse.Status {
+				return fs.Rename("a/b", "moved", newContext())
+			},
+			Listings: map[string][]string{
+				"":        {"a", "moved"},
+				"a":       {"file"},
+				"moved":   {"c"},
+				"moved/c": {"file"},
+			},
+			Missing: []string{"a/b", "a/b/c", "a/b/c/file"},
+		},
+		{
+			Op: "Rmdir",
+			Modify: func(fs pathfs.FileSystem) fuse.Status {
+				return fs.Rmdir("a/b/c", newContext())
+			},
+			Listings: map[string][]string{
+				"a/b": {},
+			},
+			Missing: []string{"a/b/c"},
+		},
+		{
+			Op: "Unlink",
+			Modify: func(fs pathfs.FileSystem) fuse.Status {
+				return fs.Unlink("a/file", newContext())
+			},
+			Listings: map[string][]string{
+				"a": {"b"},
+			},
+			Missing: []string{"a/file"},
+		},
+	} {
+		dev := newMemoryDevice(map[string]os.FileMode{
+			"/a":          os.ModeDir,
+			"/a/file":     0,
+			"/a/b":        os.ModeDir,
+			"/a/b/c":      os.ModeDir,
+			"/a/b/c/file": 0,
+		})
+		cache := NewDirEntryCache(time.Minute, time.Minute)
+		fs, err := NewAdbFileSystem(Config{
+			Mountpoint:    "",
+			ClientFactory: NewCachingDeviceClientFactory(cache, func() DeviceClient { return dev }),
+			Cache:         cache,
+		})
+		assert.NoError(t, err)
+
+		// Populate the cache with every directory, and a missing entry for the new directory.
+		for _, dir := range []string{"", "a", "a/b", "a/b/c"} {
+			_, status := fs.OpenDir(dir, newContext())
+			assertStatusOk(t, status)
+		}
+		_, status := fs.GetAttr("moved", newContext())
+		assert.Equal(t, fuse.ENOENT, status)
+
+		assert.Equal(t, fuse.OK, test.Modify(fs), test.Op)
+
+		for dir, expected := range test.Listings {
+			entries, status := fs.OpenDir(dir, newContext())
+			assert.Equal(t, fuse.OK, status, test.Op)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			assert.Equal(t, len(expected), len(names), "%s: %s", test.Op, dir)
+			for _, name := range expected {
+				assert.Contains(t, names, name, "%s: %s", test.Op, dir)
+				_, status := fs.GetAttr(path.Join(dir, name), newContext())
+				assert.Equal(t, fuse.OK, status, test.Op)
+			}
+		}
+		for _, name := range test.Missing {
+			_, status := fs.GetAttr(name, newContext())
+			assert.Equal(t, fuse.ENOENT, status, "%s: %s", test.Op, name)
+		}
+	}
+}
+
+func TestModifications_NotifyKernel(t *testing.T) {
+	dev := newMemoryDevice(map[string]os.FileMode{
+		"/root":        os.ModeDir,
+		"/root/a":      os.ModeDir,
+		"/root/a/file": 0,
+	})
+	dev.openWrite = openWriteNoop()
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		DeviceRoot:    "/root",
+		ClientFactory: func() DeviceClient { return dev },
+	})
+	assert.NoError(t, err)
+	notifier := newRecordingNotifier()
+	fs.(*AdbFileSystem).setNotifier(notifier)
+
+	assertStatusOk(t, fs.Mkdir("a/new", 0, newContext()))
+	assert.Equal(t, "entry a new", notifier.Next(t))
+	assert.Equal(t, "file a 0 0", notifier.Next(t))
+
+	assertStatusOk(t, fs.Unlink("a/file", newContext()))
+	assert.Equal(t, "entry a file", notifier.Next(t))
+	assert.Equal(t, "file a 0 0", notifier.Next(t))
+
+	// Saving a file also invalidates its contents.
+	file, status := fs.Create("created", uint32(os.O_WRONLY), 0644, newContext())
+	assertStatusOk(t, status)
+	var notifications []string
+	for i := 0; i < 5; i++ {
+		notifications = append(notifications, notifier.Next(t))
+	}
+	assert.ElementsMatch(t, []string{
+		"entry  created", "file  0 0",
+		"file created 0 0", "entry  created", "file  0 0",
+	}, notifications)
+	file.Release()
+}
+
 func TestMkdir_Error(t *testing.T) {
 	dev := &delegateDeviceClient{
 		runCommand: func(cmd string, args []string) (string, error) {
@@ -504,6 +842,84 @@ func TestUnlink_Error(t *testing.T) {
 	assert.Equal(t, fuse.EACCES, status)
 }
 
+func TestUnlink_Trash(t *testing.T) {
+	var commands []string
+	dev := &delegateDeviceClient{
+		runCommand: recordCommands(&commands, nil),
+		listDirEntries: func(path string) ([]*adb.DirEntry, error) {
+			return nil, nil
+		},
+	}
+	trash := NewTrash("", 0)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+		Trash:         trash,
+	})
+	assert.NoError(t, err)
+
+	assertStatusOk(t, fs.Unlink("dir/file.txt", newContext()))
+	assert.Len(t, commands, 1)
+	assert.True(t, strings.HasPrefix(commands[0], "sh -c mkdir -p '/.Trash-adbfs/files'"), commands[0])
+
+	commands = nil
+	assertStatusOk(t, fs.Unlink(".Trash-adbfs/files/1-file.txt", newContext()))
+	assert.Equal(t, []string{"rm /.Trash-adbfs/files/1-file.txt"}, commands)
+}
+
+func TestRmdir_Trash(t *testing.T) {
+	var commands []string
+	dev := &delegateDeviceClient{
+		runCommand: recordCommands(&commands, func(cmd string, args []string) (string, error) {
+			if strings.Contains(args[len(args)-1], "rmdir '/full'") {
+				return "rmdir: '/full': Directory not empty\n", nil
+			}
+			return "", nil
+		}),
+		listDirEntries: func(path string) ([]*adb.DirEntry, error) {
+			return nil, nil
+		},
+	}
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+		Trash:         NewTrash("", 0),
+	})
+	assert.NoError(t, err)
+
+	assertStatusOk(t, fs.Rmdir("dir", newContext()))
+	assert.Len(t, commands, 1)
+	assert.True(t, strings.HasPrefix(commands[0], "sh -c mkdir -p '/.Trash-adbfs/files'"), commands[0])
+	assert.Contains(t, commands[0], "Path=dir\n")
+	assert.Contains(t, commands[0], "{ rmdir '/dir' && mkdir '/.Trash-adbfs/files/")
+
+	assert.Equal(t, fuse.Status(syscall.ENOTEMPTY), fs.Rmdir("full", newContext()))
+
+	commands = nil
+	assertStatusOk(t, fs.Rmdir(".Trash-adbfs/files/1-dir", newContext()))
+	assert.Equal(t, []string{"rmdir /.Trash-adbfs/files/1-dir"}, commands)
+}
+
+func TestUnlink_WriteRules(t *testing.T) {
+	var commands []string
+	dev := &delegateDeviceClient{
+		runCommand: recordCommands(&commands, nil),
+	}
+	rules, err := NewWriteRules([]string{"/data/local/tmp"}, nil)
+	assert.NoError(t, err)
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+		WriteRules:    rules,
+	})
+	assert.NoError(t, err)
+
+	assertStatusOk(t, fs.Unlink("data/local/tmp/file", newContext()))
+	assert.Equal(t, fuse.Status(syscall.EROFS), fs.Unlink("data/file", newContext()))
+	assert.Equal(t, fuse.Status(syscall.EROFS), fs.Access("data/file", fuse.W_OK, newContext()))
+	assert.Equal(t, []string{"rm /data/local/tmp/file"}, commands)
+}
+
 func TestCreateFile_ExistSuccess(t *testing.T) {
 	dev := &delegateDeviceClient{
 		stat: statFiles(&adb.DirEntry{
@@ -649,12 +1065,106 @@ Inodes: Total: 327680     Free: 326438`)
 	assert.Equal(t, fuse.StatfsOut{
 		NameLen: 255,
 		Bsize:   4096,
+		Frsize:  4096,
 		Blocks:  1269664,
 		Bfree:   1209578,
 		Bavail:  1205482,
 		Files:   327680,
 		Ffree:   326438,
 	}, *stat)
+
+	// Toybox.
+	stat, err = parseStatfs(`  File: "/sdcard"
+    ID: 0 Namelen: 255    Type: fuseblk
+Block Size: 4096    Fundamental block size: 1024
+Blocks: Total: 6520182 Free: 2435186 Available: 2435186
+Inodes: Total: 1630208 Free: 1479012`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4096), stat.Bsize)
+	assert.Equal(t, uint32(1024), stat.Frsize)
+	assert.Equal(t, uint64(6520182), stat.Blocks)
+
+	_, err = parseStatfs("stat: Unknown option f\r\n")
+	assert.EqualError(t, err, "no block size")
+}
+
+func TestParseDf(t *testing.T) {
+	_, err := parseDf("df: /foo: No such file or directory\n")
+	assert.Error(t, err)
+
+	// Toolbox.
+	stat, err := parseDf(`Filesystem               Size     Used     Free   Blksize
+/sdcard                 12.0G     2.5G     9.5G   4096
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, fuse.StatfsOut{
+		Bsize:  4096,
+		Frsize: 4096,
+		Blocks: 3145728,
+		Bfree:  2490368,
+		Bavail: 2490368,
+	}, *stat)
+
+	// Toybox.
+	stat, err = parseDf("Filesystem     1K-blocks    Used Available Use% Mounted on\r\n" +
+		"/data/media     25667168 9813468  15722628  39% /storage/emulated\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, fuse.StatfsOut{
+		Bsize:  1024,
+		Frsize: 1024,
+		Blocks: 25667168,
+		Bfree:  15853700,
+		Bavail: 15722628,
+	}, *stat)
+
+	// Busybox, with a wrapped filesystem name.
+	stat, err = parseDf(`Filesystem           1K-blocks      Used Available Use% Mounted on
+/dev/block/platform/msm_sdcc.1/by-name/userdata
+                      12345678   1234567  11111111  10% /data
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12345678), stat.Blocks)
+	assert.Equal(t, uint64(11111111), stat.Bavail)
+
+	// POSIX.
+	stat, err = parseDf(`Filesystem 512-blocks Used Available Capacity Mounted on
+/dev/sda1  2000 1000 900 53% /
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(512), stat.Frsize)
+}
+
+func TestStatFs_DfFallbackCached(t *testing.T) {
+	var commands []string
+	dev := &delegateDeviceClient{
+		stat: statFiles(&adb.DirEntry{
+			Name: "/storage/1234-5678",
+			Mode: os.ModeDir,
+		}),
+		runCommand: recordCommands(&commands, func(cmd string, args []string) (string, error) {
+			if cmd == "stat" {
+				return "stat: Unknown option f\r\n", nil
+			}
+			return "Filesystem 1K-blocks Used Available Use% Mounted on\n" +
+				"/dev/fuse 2000 500 1500 25% /storage/1234-5678\n", nil
+		}),
+	}
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "",
+		ClientFactory: func() DeviceClient { return dev },
+	})
+	assert.NoError(t, err)
+
+	stat := fs.StatFs("storage/1234-5678")
+	assert.NotNil(t, stat)
+	assert.Equal(t, uint64(2000), stat.Blocks)
+	assert.Equal(t, uint32(1024), stat.Frsize)
+
+	fs.StatFs("storage/1234-5678")
+	assert.Equal(t, []string{
+		"stat -f /storage/1234-5678",
+		"df /storage/1234-5678",
+	}, commands)
 }
 
 func newContext() *fuse.Context {
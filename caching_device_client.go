@@ -4,6 +4,8 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/zach-klippenstein/goadb"
@@ -18,6 +20,21 @@ type CachingDeviceClient struct {
 type CachedDirEntries struct {
 	InOrder []*adb.DirEntry
 	ByName  map[string]*adb.DirEntry
+
+	// Results of access checks and link targets of entries, so they expire with the entries.
+	lock   sync.Mutex
+	access map[accessCheck]error
+	links  map[string]cachedLink
+}
+
+type cachedLink struct {
+	Target string
+	Err    error
+}
+
+type accessCheck struct {
+	Name string
+	Mode uint32
 }
 
 func NewCachingDeviceClientFactory(cache DirEntryCache, factory DeviceClientFactory) DeviceClientFactory {
@@ -42,6 +59,21 @@ func NewCachedDirEntries(entries []*adb.DirEntry) *CachedDirEntries {
 	return result
 }
 
+// FindFold returns the entries whose names match name, ignoring case. If an entry matches
+// exactly, only that entry is returned.
+func (e *CachedDirEntries) FindFold(name string) (matches []*adb.DirEntry) {
+	if entry, found := e.ByName[name]; found {
+		return []*adb.DirEntry{entry}
+	}
+
+	for _, entry := range e.InOrder {
+		if strings.EqualFold(entry.Name, name) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
 func (c *CachingDeviceClient) Stat(name string, log *LogEntry) (*adb.DirEntry, error) {
 	dir := path.Dir(name)
 	base := path.Base(name)
@@ -63,10 +95,19 @@ func (c *CachingDeviceClient) Stat(name string, log *LogEntry) (*adb.DirEntry, e
 		return nil, util.Errorf(util.FileNoExistError,
 			"name '%s' does not exist in cached directory listing", base)
 	}
+
+	if c.Cache.IsMissing(name) {
+		log.CacheUsed(true)
+		return nil, util.Errorf(util.FileNoExistError, "'%s' was recently found to not exist", name)
+	}
 	log.CacheUsed(false)
 
 	// The directory doesn't exist in the cache, so perform a one-off lookup on the device.
-	return c.DeviceClient.Stat(name, log)
+	entry, err := c.DeviceClient.Stat(name, log)
+	if util.HasErrCode(err, util.FileNoExistError) {
+		c.Cache.SetMissing(name)
+	}
+	return entry, err
 }
 
 func (c *CachingDeviceClient) ListDirEntries(path string, log *LogEntry) ([]*adb.DirEntry, error) {
@@ -85,13 +126,70 @@ func (c *CachingDeviceClient) ListDirEntries(path string, log *LogEntry) ([]*adb
 	return entries.InOrder, nil
 }
 
+// CheckAccess implements AccessChecker. Results are cached with the entries of the directory
+// containing name, so they're only cached while the directory listing is.
+func (c *CachingDeviceClient) CheckAccess(name string, mode uint32, log *LogEntry) error {
+	key := accessCheck{path.Base(name), mode}
+	entries, found := c.Cache.Get(path.Dir(name))
+	if found {
+		entries.lock.Lock()
+		err, checked := entries.access[key]
+		entries.lock.Unlock()
+		if checked {
+			log.CacheUsed(true)
+			return err
+		}
+	}
+	log.CacheUsed(false)
+
+	err := checkAccessWithClient(c.DeviceClient, name, mode, log)
+	if found && (err == nil || err == ErrNoPermission) {
+		entries.lock.Lock()
+		if entries.access == nil {
+			entries.access = make(map[accessCheck]error)
+		}
+		entries.access[key] = err
+		entries.lock.Unlock()
+	}
+	return err
+}
+
+// ReadLink implements LinkReader. Like CheckAccess, results are cached with the entries of the
+// directory containing name.
+func (c *CachingDeviceClient) ReadLink(name string, log *LogEntry) (string, error) {
+	base := path.Base(name)
+	entries, found := c.Cache.Get(path.Dir(name))
+	if found {
+		entries.lock.Lock()
+		link, read := entries.links[base]
+		entries.lock.Unlock()
+		if read {
+			log.CacheUsed(true)
+			return link.Target, link.Err
+		}
+	}
+	log.CacheUsed(false)
+
+	target, err := runReadlink(c.DeviceClient, name, log)
+	if found && (err == nil || err == ErrNotALink || err == ErrNoPermission) {
+		entries.lock.Lock()
+		if entries.links == nil {
+			entries.links = make(map[string]cachedLink)
+		}
+		entries.links[base] = cachedLink{target, err}
+		entries.lock.Unlock()
+	}
+	return target, err
+}
+
 func (c *CachingDeviceClient) OpenWrite(name string, perms os.FileMode, mtime time.Time, log *LogEntry) (io.WriteCloser, error) {
 	// Writing to the file obviously invalidates the file's cache entry.
+	c.Cache.RemoveMissing(name)
 	w, err := c.DeviceClient.OpenWrite(name, perms, mtime, log)
 
 	// The mtime is only set on the file on close, so don't bother invalidating the cache until then.
 	onClosed := func() {
-		c.Cache.RemoveEventually(path.Dir(name))
+		c.Cache.Remove(path.Dir(name))
 	}
 	return onCloseWriter{w, onClosed}, err
 }
@@ -78,6 +78,40 @@ func TestCachingDeviceClientStat_HitNotExists(t *testing.T) {
 	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
 }
 
+func TestCachingDeviceClientStat_Missing(t *testing.T) {
+	var statCount int
+	cache := &delegateDirEntryCache{
+		DoGet: func(path string) (entries *CachedDirEntries, found bool) {
+			return nil, false
+		},
+	}
+	client := &CachingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			stat: func(path string) (*adb.DirEntry, error) {
+				statCount++
+				return nil, util.Errorf(util.FileNoExistError, "")
+			},
+			openWrite: openWriteNoop(),
+		},
+		Cache: cache,
+	}
+
+	_, err := client.Stat("/foo/.git", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+	assert.True(t, cache.IsMissing("/foo/.git"))
+
+	_, err = client.Stat("/foo/.git", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+	assert.Equal(t, 1, statCount)
+
+	// Creating the file forgets that it was missing.
+	cache.DoRemove = func(path string) {}
+	client.OpenWrite("/foo/.git", 0644, time.Unix(0, 0), &LogEntry{})
+	assert.False(t, cache.IsMissing("/foo/.git"))
+	client.Stat("/foo/.git", &LogEntry{})
+	assert.Equal(t, 2, statCount)
+}
+
 func TestCachingDeviceClientStat_Root(t *testing.T) {
 	client := &CachingDeviceClient{
 		DeviceClient: &delegateDeviceClient{
@@ -109,7 +143,7 @@ func TestCachingDeviceClientOpenWrite(t *testing.T) {
 			openWrite: openWriteNoop(),
 		},
 		Cache: &delegateDirEntryCache{
-			DoRemoveEventually: func(path string) {
+			DoRemove: func(path string) {
 				removeCallCount++
 			},
 		},
@@ -122,3 +156,53 @@ func TestCachingDeviceClientOpenWrite(t *testing.T) {
 	w.Close()
 	assert.Equal(t, 1, removeCallCount)
 }
+
+func TestCachingDeviceClientReadLink(t *testing.T) {
+	var readlinks int
+	entries := NewCachedDirEntries([]*adb.DirEntry{{Name: "link"}})
+	cached := true
+	client := &CachingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			runCommand: func(cmd string, args []string) (string, error) {
+				readlinks++
+				if args[0] == "/foo/file" {
+					return ReadlinkInvalidArgument, nil
+				}
+				return "/system/etc\r\n", nil
+			},
+		},
+		Cache: &delegateDirEntryCache{
+			DoGet: func(path string) (*CachedDirEntries, bool) {
+				return entries, cached
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		target, err := readLink(client, "/foo/link", &LogEntry{})
+		assert.NoError(t, err)
+		assert.Equal(t, "/system/etc", target)
+		_, err = readLink(client, "/foo/file", &LogEntry{})
+		assert.Equal(t, ErrNotALink, err)
+	}
+	assert.Equal(t, 2, readlinks)
+
+	// Results aren't cached if the directory isn't.
+	cached = false
+	readLink(client, "/bar/link", &LogEntry{})
+	readLink(client, "/bar/link", &LogEntry{})
+	assert.Equal(t, 4, readlinks)
+}
+
+func TestCachedDirEntriesFindFold(t *testing.T) {
+	entries := NewCachedDirEntries([]*adb.DirEntry{
+		&adb.DirEntry{Name: "Camera"},
+		&adb.DirEntry{Name: "photo.jpg"},
+		&adb.DirEntry{Name: "Photo.jpg"},
+	})
+
+	assert.Equal(t, []*adb.DirEntry{entries.InOrder[0]}, entries.FindFold("camera"))
+	assert.Equal(t, []*adb.DirEntry{entries.InOrder[2]}, entries.FindFold("Photo.jpg"))
+	assert.Len(t, entries.FindFold("PHOTO.JPG"), 2)
+	assert.Empty(t, entries.FindFold("video.mp4"))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	fs "github.com/zach-klippenstein/adbfs"
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+	"github.com/zach-klippenstein/goadb"
+)
+
+// deviceMounter maintains a subdirectory of a MultiFileSystem for every online device.
+type deviceMounter struct {
+	server     adb.Server
+	root       *fs.MultiFileSystem
+	mountpoint string
+	aliases    map[string]string
+
+	lock sync.Mutex
+	// Incremented every time a device comes online or goes offline, so a filesystem that finishes
+	// initializing after its device has gone away again isn't added.
+	generations map[string]int
+}
+
+func initializeAllDevicesFileSystem(server adb.Server, mountpoint string) pathfs.FileSystem {
+	aliases, err := cli.ParseDeviceAliases(config.DeviceAliases)
+	if err != nil {
+		cli.Log.Fatal(err)
+	}
+
+	mounter := &deviceMounter{
+		server:      server,
+		root:        fs.NewMultiFileSystem(),
+		mountpoint:  mountpoint,
+		aliases:     aliases,
+		generations: make(map[string]int),
+	}
+	go mounter.watchDevices()
+	return mounter.root
+}
+
+func (m *deviceMounter) watchDevices() {
+	watcher := adb.NewDeviceWatcher(m.server)
+	defer watcher.Shutdown()
+
+	for event := range watcher.C() {
+		if event.CameOnline() {
+			cli.Log.Infoln("device connected:", event.Serial)
+			go m.addDevice(event.Serial, m.nextGeneration(event.Serial))
+		} else if event.WentOffline() {
+			cli.Log.Infoln("device disconnected:", event.Serial)
+			m.removeDevice(event.Serial)
+		}
+	}
+
+	if err := watcher.Err(); err != nil {
+		cli.Log.Warn("DeviceWatcher disconnected with error:", err)
+	}
+}
+
+func (m *deviceMounter) addDevice(serial string, generation int) {
+	name := m.dirName(serial)
+	if owner, ok := m.aliasOwner(serial); ok {
+		cli.Log.Errorf("not mounting %s: its serial is the alias of %s", serial, owner)
+		return
+	}
+
+	deviceFs, err := initializeDeviceFileSystem(m.server, serial, filepath.Join(m.mountpoint, name), func() {
+		m.removeDevice(serial)
+	})
+	if err != nil {
+		cli.Log.Errorf("error mounting device %s: %s", serial, err)
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.generations[serial] != generation {
+		cli.Log.Debugf("device %s changed state while initializing, not mounting", serial)
+		deviceFs.OnUnmount()
+		return
+	}
+	m.root.AddChild(name, deviceFs)
+	cli.Log.Infof("mounted %s on %s", serial, name)
+}
+
+func (m *deviceMounter) removeDevice(serial string) {
+	m.nextGeneration(serial)
+	if _, ok := m.aliasOwner(serial); ok {
+		// It was never mounted, and its directory belongs to another device.
+		return
+	}
+	if deviceFs := m.root.RemoveChild(m.dirName(serial)); deviceFs != nil {
+		deviceFs.OnUnmount()
+		cli.Log.Infoln("unmounted", serial)
+	}
+}
+
+func (m *deviceMounter) nextGeneration(serial string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.generations[serial]++
+	return m.generations[serial]
+}
+
+// dirName returns the name of the subdirectory for the device with serial.
+func (m *deviceMounter) dirName(serial string) string {
+	return cli.DeviceDirName(serial, m.aliases)
+}
+
+// aliasOwner returns the other device whose alias is the directory name of serial, if any.
+// ParseDeviceAliases only rejects collisions between devices that both have aliases.
+func (m *deviceMounter) aliasOwner(serial string) (string, bool) {
+	name := m.dirName(serial)
+	for aliased, alias := range m.aliases {
+		if alias == name && aliased != serial {
+			return aliased, true
+		}
+	}
+	return "", false
+}
@@ -10,10 +10,13 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -36,22 +39,36 @@ var (
 
 	// Prevents trying to unmount the server multiple times.
 	unmounted AtomicBool
+
+	// Shell profiles of devices by serial, for the debug server.
+	shellProfilesLock sync.Mutex
+	shellProfiles     = make(map[string]*fs.ShellProfile)
 )
 
 func init() {
 	cli.RegisterAdbfsFlags(&config)
+	cli.RegisterDebugPage("Device shell profiles", "/debug/shell", http.HandlerFunc(serveShellProfiles))
 }
 
 func main() {
 	cli.Initialize("adbfs", &config.BaseConfig)
 
-	if config.DeviceSerial == "" {
+	if config.AllDevices && config.DeviceSerial != "" {
+		cli.Log.Fatalln("--device and --all-devices can't be used together.")
+	}
+	if !config.AllDevices && config.DeviceSerial == "" {
 		cli.Log.Fatalln("Device serial must be specified. Run with -h.")
 	}
 
 	if config.Mountpoint == "" {
 		cli.Log.Fatalln("Mountpoint must be specified. Run with -h.")
 	}
+	if config.RunAsPackage != "" && config.AsRoot {
+		cli.Log.Fatalln("--run-as and --as-root can't be used together.")
+	}
+	if config.RunAsPackage != "" && config.DeviceRoot == cli.DefaultDeviceRoot {
+		config.DeviceRoot = "/data/data/" + config.RunAsPackage
+	}
 	absoluteMountpoint, err := filepath.Abs(config.Mountpoint)
 	if err != nil {
 		cli.Log.Fatal(err)
@@ -60,16 +77,25 @@ func main() {
 		cli.Log.Fatal(err)
 	}
 
-	cache := initializeCache(config.CacheTtl)
 	adbServer, err := adb.NewServer(config.ServerConfig())
 	if err != nil {
 		cli.Log.Fatal(err)
 	}
 
-	fs := initializeFileSystem(adbServer, absoluteMountpoint, cache)
-	go watchForDeviceDisconnected(adbServer, config.DeviceSerial)
+	var fsImpl pathfs.FileSystem
+	if config.AllDevices {
+		fsImpl = initializeAllDevicesFileSystem(adbServer, absoluteMountpoint)
+	} else {
+		fsImpl, err = initializeDeviceFileSystem(adbServer, config.DeviceSerial, absoluteMountpoint, handleDeviceDisconnected)
+		if err != nil {
+			cli.Log.Fatal(err)
+		}
+		go watchForDeviceDisconnected(adbServer, config.DeviceSerial)
+	}
+	// ClientInodes isn't useful, since the sync service doesn't report inode numbers.
+	fs := pathfs.NewPathNodeFs(fsImpl, nil)
 
-	server, _, err = nodefs.MountRoot(absoluteMountpoint, fs.Root(), nil)
+	server, _, err = nodefs.MountRoot(absoluteMountpoint, fs.Root(), initializeKernelOptions())
 	if err != nil {
 		cli.Log.Fatal(err)
 	}
@@ -78,7 +104,11 @@ func main() {
 	if err != nil {
 		cli.Log.Fatal(err)
 	}
-	cli.Log.Printf("mounted %s on %s", config.DeviceSerial, absoluteMountpoint)
+	if config.AllDevices {
+		cli.Log.Printf("mounted all devices on %s", absoluteMountpoint)
+	} else {
+		cli.Log.Printf("mounted %s on %s", config.DeviceSerial, absoluteMountpoint)
+	}
 	mounted.CompareAndSwap(false, true)
 	defer unmountServer()
 
@@ -102,29 +132,205 @@ func main() {
 	}
 }
 
-func initializeCache(ttl time.Duration) fs.DirEntryCache {
-	cli.Log.Infoln("stat cache ttl:", ttl)
-	return fs.NewDirEntryCache(ttl)
+// initializeKernelOptions returns the options that control how long the kernel caches what the
+// filesystem tells it.
+func initializeKernelOptions() *nodefs.Options {
+	cli.Log.Infof("kernel cache timeouts: entry=%s, attr=%s, negative=%s",
+		config.EntryTimeout, config.AttrTimeout, config.NegativeTimeout)
+	opts := nodefs.NewOptions()
+	opts.EntryTimeout = config.EntryTimeout
+	opts.AttrTimeout = config.AttrTimeout
+	opts.NegativeTimeout = config.NegativeTimeout
+	return opts
 }
 
-func initializeFileSystem(server adb.Server, mountpoint string, cache fs.DirEntryCache) *pathfs.PathNodeFs {
-	clientFactory := fs.NewCachingDeviceClientFactory(cache,
-		fs.NewGoadbDeviceClientFactory(server, config.DeviceSerial, handleDeviceDisconnected))
+func initializeCache(ttl, negativeTtl time.Duration) fs.DirEntryCache {
+	cli.Log.Infof("stat cache ttl: %s, negative: %s", ttl, negativeTtl)
+	return fs.NewDirEntryCache(ttl, negativeTtl)
+}
+
+func initializeScheduler() *fs.Scheduler {
+	cli.Log.Infof("max concurrent operations: metadata=%d, transfers=%d", config.MaxMetadataOps, config.MaxTransfers)
+	return fs.NewScheduler(fs.SchedulerOptions{
+		MaxMetadataOperations: config.MaxMetadataOps,
+		MaxBulkOperations:     config.MaxTransfers,
+	})
+}
+
+// initializeDeviceFileSystem creates the filesystem for the device with serial, with its own
+// cache, scheduler, and client pool.
+func initializeDeviceFileSystem(server adb.Server, serial, mountpoint string, deviceDisconnectedHandler func()) (pathfs.FileSystem, error) {
+	cache := initializeCache(config.CacheTtl, config.NegativeCacheTtl)
+
+	clientFactory := fs.NewGoadbDeviceClientFactory(server, serial, deviceDisconnectedHandler)
+	if config.RunAsPackage != "" {
+		cli.Log.Infoln("running commands as package:", config.RunAsPackage)
+		clientFactory = fs.NewRunAsDeviceClientFactory(config.RunAsPackage, clientFactory)
+	}
+	if config.AsRoot {
+		cli.Log.Infoln("root access requested, probing device…")
+		clientFactory = fs.NewRootDeviceClientFactory(clientFactory)
+	}
+	clientFactory = initializeShellProfile(serial, clientFactory)
+	clientFactory = fs.NewSchedulingDeviceClientFactory(initializeScheduler(), clientFactory)
+
+	var roots []cli.DeviceRoot
+	devicePaths := []string{config.DeviceRoot}
+	if config.DeviceRoots != "" {
+		var err error
+		if roots, err = cli.ParseDeviceRoots(config.DeviceRoots); err != nil {
+			return nil, err
+		}
+		devicePaths = nil
+		for _, root := range roots {
+			devicePaths = append(devicePaths, root.Path)
+		}
+	}
+
+	var excludes *fs.Excludes
+	if len(config.Excludes) > 0 {
+		cli.Log.Infoln("excluding:", config.Excludes)
+		var err error
+		if excludes, err = fs.NewExcludes(devicePaths, config.Excludes); err != nil {
+			return nil, err
+		}
+		// Excluded paths must be filtered before they're cached.
+		clientFactory = fs.NewExcludingDeviceClientFactory(excludes, clientFactory)
+	}
+	clientFactory = fs.NewCachingDeviceClientFactory(cache, clientFactory)
 
 	var fsImpl pathfs.FileSystem
-	fsImpl, err := fs.NewAdbFileSystem(fs.Config{
-		DeviceSerial:       config.DeviceSerial,
+	var searchRoots []fs.SearchRoot
+	var trashes []*fs.Trash
+	if len(roots) > 0 {
+		for _, root := range roots {
+			searchRoots = append(searchRoots, fs.SearchRoot{MountPath: root.Name, DevicePath: root.Path})
+			if config.UseTrash {
+				trashes = append(trashes, fs.NewTrash(root.Path, config.TrashExpiry))
+			}
+		}
+		var err error
+		if fsImpl, err = initializeMultiFileSystem(serial, mountpoint, roots, trashes, cache, excludes, clientFactory); err != nil {
+			return nil, err
+		}
+	} else {
+		var trash *fs.Trash
+		if config.UseTrash {
+			trash = fs.NewTrash(config.DeviceRoot, config.TrashExpiry)
+			trashes = append(trashes, trash)
+		}
+		var err error
+		if fsImpl, err = initializeAdbFileSystem(serial, mountpoint, config.DeviceRoot, trash, cache, excludes, clientFactory); err != nil {
+			return nil, err
+		}
+		searchRoots = []fs.SearchRoot{{DevicePath: config.DeviceRoot}}
+	}
+
+	return fs.NewControlFileSystem(fsImpl, fs.ControlConfig{
+		DeviceSerial:      serial,
+		ClientFactory:     clientFactory,
+		ReadOnly:          config.ReadOnly,
+		Visible:           config.ShowControlDir,
+		PropsTtl:          config.PropsCacheTtl,
+		OpenShellStream:   fs.NewAdbShellStreamOpener(config.ServerAddress(), serial),
+		OnInstallHandlers: config.OnInstallHandlers,
+		SearchRoots:       searchRoots,
+		Excludes:          excludes,
+		Trashes:           trashes,
+	}), nil
+}
+
+// initializeShellProfile probes the commands available on the device, and returns a factory that
+// adapts commands to them. The profile is shown on the debug server.
+func initializeShellProfile(serial string, clientFactory fs.DeviceClientFactory) fs.DeviceClientFactory {
+	profile, err := fs.ProbeShellProfile(clientFactory())
+	if err != nil {
+		cli.Log.Warnln("error probing device shell, commands won't be adapted:", err)
+		return clientFactory
+	}
+	cli.Log.Infof("shell profile:\n%s", profile)
+
+	// Replaces the profile from the device's last connection.
+	shellProfilesLock.Lock()
+	shellProfiles[serial] = profile
+	shellProfilesLock.Unlock()
+
+	return fs.NewShellAdapterDeviceClientFactory(profile, clientFactory)
+}
+
+// serveShellProfiles prints the shell profile of every device that's been connected.
+func serveShellProfiles(w http.ResponseWriter, req *http.Request) {
+	shellProfilesLock.Lock()
+	defer shellProfilesLock.Unlock()
+
+	serials := make([]string, 0, len(shellProfiles))
+	for serial := range shellProfiles {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, serial := range serials {
+		fmt.Fprintf(w, "%s:\n%s\n", serial, shellProfiles[serial])
+	}
+}
+
+// initializeMultiFileSystem mounts each of roots in a subdirectory. If trashes is not empty, it
+// contains the trash for each root.
+func initializeMultiFileSystem(serial, mountpoint string, roots []cli.DeviceRoot, trashes []*fs.Trash, cache fs.DirEntryCache, excludes *fs.Excludes, clientFactory fs.DeviceClientFactory) (pathfs.FileSystem, error) {
+	multiFs := fs.NewMultiFileSystem()
+	for i, root := range roots {
+		cli.Log.Infof("mounting device root %s on %s", root.Path, root.Name)
+		childMountpoint := filepath.Join(mountpoint, root.Name)
+		var trash *fs.Trash
+		if len(trashes) > 0 {
+			trash = trashes[i]
+		}
+		childFs, err := initializeAdbFileSystem(serial, childMountpoint, root.Path, trash, cache, excludes, clientFactory)
+		if err != nil {
+			return nil, err
+		}
+		multiFs.AddChild(root.Name, childFs)
+	}
+	return multiFs, nil
+}
+
+func initializeAdbFileSystem(serial, mountpoint, deviceRoot string, trash *fs.Trash, cache fs.DirEntryCache, excludes *fs.Excludes, clientFactory fs.DeviceClientFactory) (pathfs.FileSystem, error) {
+	writeRules, err := initializeWriteRules(serial)
+	if err != nil {
+		return nil, err
+	}
+	symlinkPolicy, err := fs.ParseSymlinkPolicy(config.SymlinkPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.NewAdbFileSystem(fs.Config{
+		DeviceSerial:       serial,
 		Mountpoint:         mountpoint,
 		ClientFactory:      clientFactory,
+		Cache:              cache,
+		Excludes:           excludes,
 		ConnectionPoolSize: config.ConnectionPoolSize,
-		DeviceRoot:         config.DeviceRoot,
+		DeviceRoot:         deviceRoot,
 		ReadOnly:           config.ReadOnly,
+		Trash:              trash,
+		WriteRules:         writeRules,
+		CaseInsensitive:    config.CaseInsensitive,
+		SymlinkPolicy:      symlinkPolicy,
 	})
+}
+
+func initializeWriteRules(serial string) (fs.WriteRules, error) {
+	allow, err := cli.WriteRulesForDevice(config.AllowWrites, serial)
 	if err != nil {
-		cli.Log.Fatal(err)
+		return fs.WriteRules{}, err
 	}
-
-	return pathfs.NewPathNodeFs(fsImpl, nil)
+	deny, err := cli.WriteRulesForDevice(config.DenyWrites, serial)
+	if err != nil {
+		return fs.WriteRules{}, err
+	}
+	return fs.NewWriteRules(allow, deny)
 }
 
 func watchForDeviceDisconnected(server adb.Server, serial string) {
@@ -0,0 +1,37 @@
+package adbfs
+
+import (
+	"time"
+
+	cache "github.com/pmylund/go-cache"
+)
+
+// controlCache caches values loaded from the device for the control directory.
+type controlCache struct {
+	cache *cache.Cache
+}
+
+func newControlCache(ttl time.Duration) *controlCache {
+	return &controlCache{
+		cache: cache.New(ttl, CachePurgeInterval),
+	}
+}
+
+// GetOrLoad returns the value for key, calling load if it's not cached. Errors are not cached.
+func (c *controlCache) GetOrLoad(key string, load func() (interface{}, error)) (interface{}, error) {
+	if value, found := c.cache.Get(key); found {
+		return value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Set(key, value, cache.DefaultExpiration)
+	return value, nil
+}
+
+// Clear removes all cached values, e.g. after the device has been modified.
+func (c *controlCache) Clear() {
+	c.cache.Flush()
+}
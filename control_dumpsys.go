@@ -0,0 +1,170 @@
+package adbfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+const (
+	// How long to cache the list of services.
+	DumpsysServicesCacheTtl = 10 * time.Second
+
+	// How long to wait for dumpsys to dump a single service.
+	DumpsysTimeout = 10 * time.Second
+
+	// Output of a single service beyond this many bytes is replaced with a marker.
+	DumpsysMaxOutputSize = 4 * 1024 * 1024
+)
+
+/*
+dumpsysControlDir is a ControlDir that contains a dumpsysControlFile for every system service.
+
+dumpsys is run over its own shell stream instead of a DeviceClient, so a service that hangs can be
+stopped by closing the stream, and doesn't hold a Scheduler slot while it's hung.
+*/
+type dumpsysControlDir struct {
+	openStream ShellStreamOpener
+	cache      *controlCache
+	timeout    time.Duration
+	maxSize    int
+}
+
+func newDumpsysControlDir(openStream ShellStreamOpener) *dumpsysControlDir {
+	return &dumpsysControlDir{
+		openStream: openStream,
+		cache:      newControlCache(DumpsysServicesCacheTtl),
+		timeout:    DumpsysTimeout,
+		maxSize:    DumpsysMaxOutputSize,
+	}
+}
+
+// services returns the names of all services that can be dumped.
+func (d *dumpsysControlDir) services() (map[string]bool, error) {
+	services, err := d.cache.GetOrLoad("services", func() (interface{}, error) {
+		output, err := runStreamWithTimeout(d.openStream, d.timeout, "dumpsys -l", DumpsysMaxOutputSize)
+		if err != nil {
+			return nil, err
+		}
+		return parseDumpsysListOutput(output), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services.(map[string]bool), nil
+}
+
+func (d *dumpsysControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *dumpsysControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	services, err := d.services()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(services))
+	for name := range services {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *dumpsysControlDir) Child(name string) (ControlNode, error) {
+	services, err := d.services()
+	if err != nil {
+		return nil, err
+	}
+	if !services[name] {
+		return nil, syscall.ENOENT
+	}
+	return &dumpsysControlFile{d, name}, nil
+}
+
+// dumpsysControlFile is a read-only ControlFile that dumps a service every time it's opened.
+type dumpsysControlFile struct {
+	dir     *dumpsysControlDir
+	service string
+}
+
+func (f *dumpsysControlFile) GetAttr() (*fuse.Attr, error) {
+	// The size isn't known until the service is dumped.
+	return newControlFileAttr(0444, 0), nil
+}
+
+func (f *dumpsysControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	if flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+
+	output, err := runStreamWithTimeout(f.dir.openStream, f.dir.timeout, "dumpsys "+quoteShellArg(f.service), f.dir.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) > f.dir.maxSize {
+		output = output[:f.dir.maxSize] + fmt.Sprintf("\n[adbfs: output truncated to %d bytes]\n", f.dir.maxSize)
+	}
+	return newReadOnlyDataFile([]byte(output), flags)
+}
+
+// runStreamWithTimeout runs command with openStream, and returns at most maxSize+1 bytes of its
+// output. If the command doesn't finish in time, the stream is closed, which stops the command on
+// the device, and ETIMEDOUT is returned.
+func runStreamWithTimeout(openStream ShellStreamOpener, timeout time.Duration, command string, maxSize int) (string, error) {
+	stream, err := openStream(command)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	timer := time.AfterFunc(timeout, func() {
+		stream.Close()
+	})
+
+	output, err := ioutil.ReadAll(io.LimitReader(stream, int64(maxSize)+1))
+	if !timer.Stop() && err != nil {
+		return "", syscall.ETIMEDOUT
+	}
+	return string(output), err
+}
+
+/*
+parseDumpsysListOutput parses the output of dumpsys -l and returns the set of service names.
+Services whose names can't be used as file names are skipped.
+
+Sample output:
+
+	Currently running services:
+	  DockObserver
+	  SurfaceFlinger
+	  activity
+*/
+func parseDumpsysListOutput(output string) map[string]bool {
+	services := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		name := strings.TrimSpace(line)
+		if name == "" || !strings.HasPrefix(line, " ") {
+			// The header isn't indented.
+			continue
+		}
+		if isValidControlName(name) {
+			services[name] = true
+		}
+	}
+	return services
+}
@@ -0,0 +1,80 @@
+package adbfs
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDumpsysListOutput(t *testing.T) {
+	services := parseDumpsysListOutput("Currently running services:\r\n  DockObserver\r\n  activity\r\n  a/b\r\n\r\n")
+	assert.Equal(t, map[string]bool{
+		"DockObserver": true,
+		"activity":     true,
+	}, services)
+}
+
+func TestDumpsysControlDir(t *testing.T) {
+	dir := newDumpsysControlDir(func(command string) (io.ReadCloser, error) {
+		switch command {
+		case "dumpsys -l":
+			return ioutil.NopCloser(strings.NewReader("Currently running services:\n  activity\n  battery\n")), nil
+		case "dumpsys 'activity'":
+			return ioutil.NopCloser(strings.NewReader("ACTIVITY MANAGER\n")), nil
+		case "dumpsys 'battery'":
+			return ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 30))), nil
+		}
+		t.Fatal("invalid command:", command)
+		return nil, nil
+	})
+	dir.maxSize = 18
+
+	entries, err := dir.ListChildren()
+	assert.NoError(t, err)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "activity", Mode: fuse.S_IFREG},
+		{Name: "battery", Mode: fuse.S_IFREG},
+	}, entries)
+
+	_, err = dir.Child("window")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	assert.Equal(t, "ACTIVITY MANAGER\n", readControlFile(t, dir, "activity"))
+	assert.Equal(t, strings.Repeat("x", 18)+"\n[adbfs: output truncated to 18 bytes]\n", readControlFile(t, dir, "battery"))
+}
+
+func TestDumpsysControlDir_TimeoutStopsCommand(t *testing.T) {
+	var stream *io.PipeReader
+	dir := newDumpsysControlDir(func(command string) (io.ReadCloser, error) {
+		// Nothing is ever written, like a service that hangs.
+		stream, _ = io.Pipe()
+		return stream, nil
+	})
+	dir.timeout = time.Millisecond
+
+	_, err := dir.ListChildren()
+	assert.Equal(t, syscall.ETIMEDOUT, err)
+
+	// Closing the stream is what stops the command on the device.
+	_, err = stream.Read(make([]byte, 1))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func readControlFile(t *testing.T, dir ControlDir, name string) string {
+	node, err := dir.Child(name)
+	assert.NoError(t, err)
+	file, err := node.(ControlFile).Open(O_RDONLY, &LogEntry{})
+	assert.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	return string(data)
+}
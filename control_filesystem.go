@@ -0,0 +1,678 @@
+package adbfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// Name of the virtual directory in the root of the filesystem that exposes device information.
+const ControlDirName = ".adbfs"
+
+// ControlNode is a virtual file or directory inside the control directory.
+type ControlNode interface {
+	GetAttr() (*fuse.Attr, error)
+}
+
+// ControlDir is a ControlNode that contains other nodes.
+type ControlDir interface {
+	ControlNode
+	ListChildren() ([]fuse.DirEntry, error)
+	// Child returns the node called name, or syscall.ENOENT if there is no such node.
+	Child(name string) (ControlNode, error)
+}
+
+// ControlFile is a ControlNode that can be opened.
+type ControlFile interface {
+	ControlNode
+	Open(flags FileOpenFlags, logEntry *LogEntry) (nodefs.File, error)
+}
+
+// ControlSymlink is a ControlNode that is a symbolic link.
+type ControlSymlink interface {
+	ControlNode
+	Readlink() (string, error)
+}
+
+// ControlCreatableDir is a ControlDir in which new files can be created.
+type ControlCreatableDir interface {
+	ControlDir
+	Create(name string, flags FileOpenFlags, perms os.FileMode, logEntry *LogEntry) (nodefs.File, error)
+}
+
+// ControlRemovableDir is a ControlDir whose children can be removed.
+type ControlRemovableDir interface {
+	ControlDir
+	Remove(name string) error
+}
+
+// closableControlNode is a ControlNode that holds resources that must be released when the
+// filesystem is unmounted.
+type closableControlNode interface {
+	ControlNode
+	close()
+}
+
+// ControlTruncatableFile is a ControlFile that can be truncated without being opened.
+type ControlTruncatableFile interface {
+	ControlFile
+	Truncate(size uint64) error
+}
+
+// ControlConfig stores arguments used by ControlFileSystem.
+type ControlConfig struct {
+	// Serial number of the device for which ClientFactory returns clients.
+	DeviceSerial string
+
+	// Used to create the client that's shared by every control node to query the device.
+	ClientFactory DeviceClientFactory
+
+	// If true, nothing in the control directory can be modified.
+	ReadOnly bool
+
+	// If true, the control directory is included in listings of the root directory.
+	// It's always accessible by path.
+	Visible bool
+
+	// How long to cache device properties.
+	PropsTtl time.Duration
+
+	// The device directories that are mounted, searched by the search directory.
+	SearchRoots []SearchRoot
+
+	// If not nil, paths excluded from the mount, which are left out of search results.
+	Excludes *Excludes
+
+	// The trashes of the mounted device directories, emptied by the empty-trash file. If empty,
+	// the file is not available.
+	Trashes []*Trash
+
+	// Used to stream logcat and dumpsys output. If nil, the logcat files and dumpsys directory
+	// are not available.
+	OpenShellStream ShellStreamOpener
+
+	// Commands to run after an APK is installed through the install directory.
+	// See cli.FireHandlers.
+	OnInstallHandlers []string
+}
+
+/*
+ControlFileSystem is an implementation of fuse.pathfs.FileSystem that adds a virtual control
+directory, named ControlDirName, to the root of another filesystem. Paths inside the control
+directory are served from a tree of ControlNodes, and all other paths are passed through.
+
+The control directory currently contains:
+
+	props/<name>	One read-only file for every system property, containing its value.
+	logcat		The output of logcat, which grows as long as the file is open, so it can be
+			followed with tail -f. logcat-<buffer> files show a single log buffer.
+	packages/<package>/<apk>
+			The APKs of every installed package, including splits, read directly from the device.
+	install/	APKs written to this directory are installed when closed, and the output of
+			pm install is written to <name>.result.
+	screenshot.png	A new screenshot of the device every time it's opened.
+	dumpsys/<service>
+			The output of dumpsys for every running service, generated when opened.
+	settings/<namespace>/<key>
+			The value of every setting in the system, secure, and global namespaces. Values
+			written to these files are applied with settings put when closed.
+	search/<query>/	Symlinks to the files matching a URL-encoded query, found with find on the
+			device. See searchControlDir.
+	empty-trash	Writing to this file permanently deletes everything in the trash, if enabled.
+*/
+type ControlFileSystem struct {
+	pathfs.FileSystem
+	config    ControlConfig
+	root      ControlDir
+	openFiles *OpenFiles
+	closables []closableControlNode
+}
+
+var (
+	_ pathfs.FileSystem   = &ControlFileSystem{}
+	_ notifyingFileSystem = &ControlFileSystem{}
+)
+
+func NewControlFileSystem(delegate pathfs.FileSystem, config ControlConfig) *ControlFileSystem {
+	// Nodes are looked up on every access, so creating a client each time would be wasteful.
+	// Clients are safe to use concurrently, and their scheduler limits how much runs at once.
+	if config.ClientFactory != nil {
+		config.ClientFactory = sharedClientFactory(config.ClientFactory)
+	}
+
+	openFiles := NewOpenFiles(OpenFilesOptions{
+		ClientFactory: config.ClientFactory,
+	})
+
+	children := map[string]ControlNode{
+		"props":    newPropsControlDir(NewDevicePropsCache(config.ClientFactory, config.PropsTtl)),
+		"packages": newPackagesControlDir(config.ClientFactory, openFiles),
+		"install":  newInstallControlDir(config),
+		"settings": newSettingsControlDir(config.ClientFactory, config.ReadOnly),
+		"search":   newSearchControlDir(config.ClientFactory, config.SearchRoots, config.Excludes),
+
+		ScreenshotControlFileName: &screenshotControlFile{config.ClientFactory},
+	}
+	if config.OpenShellStream != nil {
+		addLogcatControlFiles(children, config.OpenShellStream)
+		children["dumpsys"] = newDumpsysControlDir(config.OpenShellStream)
+	}
+	if len(config.Trashes) > 0 {
+		children[EmptyTrashControlFileName] = newEmptyTrashControlFile(config.ClientFactory, config.Trashes)
+	}
+
+	var closables []closableControlNode
+	for _, child := range children {
+		if child, ok := child.(closableControlNode); ok {
+			closables = append(closables, child)
+		}
+	}
+
+	return &ControlFileSystem{
+		FileSystem: delegate,
+		config:     config,
+		root:       newStaticControlDir(children),
+		openFiles:  openFiles,
+		closables:  closables,
+	}
+}
+
+// sharedClientFactory returns a factory that creates a client with factory the first time it's
+// called, and returns that client every time after.
+func sharedClientFactory(factory DeviceClientFactory) DeviceClientFactory {
+	var once sync.Once
+	var client DeviceClient
+	return func() DeviceClient {
+		once.Do(func() {
+			client = factory()
+		})
+		return client
+	}
+}
+
+// controlPath returns the path of name relative to the control directory, and true if name is
+// inside it.
+func controlPath(name string) (string, bool) {
+	name = strings.Trim(name, "/")
+	if name == ControlDirName {
+		return "", true
+	}
+	if strings.HasPrefix(name, ControlDirName+"/") {
+		return strings.TrimPrefix(name, ControlDirName+"/"), true
+	}
+	return "", false
+}
+
+func (fs *ControlFileSystem) lookup(name string) (ControlNode, error) {
+	var node ControlNode = fs.root
+	if name == "" {
+		return node, nil
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		dir, ok := node.(ControlDir)
+		if !ok {
+			return nil, syscall.ENOTDIR
+		}
+
+		var err error
+		node, err = dir.Child(component)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// splitControlPath splits a path relative to the control directory into the path of its parent
+// and its name. The parent of a top-level entry is "", the control directory itself.
+func splitControlPath(relName string) (parentName, baseName string) {
+	parentName, baseName = path.Split(relName)
+	return strings.TrimSuffix(parentName, "/"), baseName
+}
+
+func (fs *ControlFileSystem) String() string {
+	return fmt.Sprintf("ControlFileSystem(%s)", fs.FileSystem)
+}
+
+// setNotifier passes notifier to the wrapped filesystem, whose paths aren't changed.
+func (fs *ControlFileSystem) setNotifier(notifier Notifier) {
+	if delegate, ok := fs.FileSystem.(notifyingFileSystem); ok {
+		delegate.setNotifier(notifier)
+	}
+}
+
+// OnUnmount releases the control nodes' resources, e.g. running logcat streams, as well as the
+// wrapped filesystem's.
+func (fs *ControlFileSystem) OnUnmount() {
+	fs.openFiles.Close()
+	for _, node := range fs.closables {
+		node.close()
+	}
+	fs.FileSystem.OnUnmount()
+}
+
+func (fs *ControlFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.GetAttr(name, context)
+	}
+
+	logEntry := StartOperation("GetAttr", name)
+	defer logEntry.SuppressFinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+	attr, err := node.GetAttr()
+	if err == nil {
+		logEntry.Result("attr=%v", attr)
+	}
+	return attr, toFuseStatusLog(err, logEntry)
+}
+
+func (fs *ControlFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	relName, ok := controlPath(name)
+	if !ok {
+		entries, status := fs.FileSystem.OpenDir(name, context)
+		if status.Ok() && fs.config.Visible && strings.Trim(name, "/") == "" {
+			entries = append(entries, fuse.DirEntry{
+				Name: ControlDirName,
+				Mode: fuse.S_IFDIR,
+			})
+		}
+		return entries, status
+	}
+
+	logEntry := StartOperation("OpenDir", name)
+	defer logEntry.FinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+	dir, ok := node.(ControlDir)
+	if !ok {
+		return nil, toFuseStatusLog(syscall.ENOTDIR, logEntry)
+	}
+
+	entries, err := dir.ListChildren()
+	if err == nil {
+		logEntry.Result("%d entries", len(entries))
+	}
+	return entries, toFuseStatusLog(err, logEntry)
+}
+
+func (fs *ControlFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Open(name, flags, context)
+	}
+
+	logEntry := StartOperation("Open", name)
+	defer logEntry.FinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+	file, ok := node.(ControlFile)
+	if !ok {
+		return nil, toFuseStatusLog(syscall.EISDIR, logEntry)
+	}
+
+	f, err := file.Open(FileOpenFlags(flags), logEntry)
+	if err == nil {
+		logEntry.Result("%s", f)
+	}
+	return f, toFuseStatusLog(err, logEntry)
+}
+
+// Access checks mode against the owner permission bits of the node.
+func (fs *ControlFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Access(name, mode, context)
+	}
+
+	logEntry := StartOperation("Access", name)
+	defer logEntry.SuppressFinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	attr, err := node.GetAttr()
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+
+	// R_OK, W_OK, and X_OK line up with the owner permission bits shifted right by 6.
+	if ownerPerms := (attr.Mode >> 6) & 07; mode&ownerPerms != mode {
+		return toFuseStatusLog(ErrNoPermission, logEntry)
+	}
+	return toFuseStatusLog(OK, logEntry)
+}
+
+func (fs *ControlFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Readlink(name, context)
+	}
+
+	logEntry := StartOperation("Readlink", name)
+	defer logEntry.FinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return "", toFuseStatusLog(err, logEntry)
+	}
+	link, ok := node.(ControlSymlink)
+	if !ok {
+		return "", toFuseStatusLog(ErrNotALink, logEntry)
+	}
+	target, err := link.Readlink()
+	if err == nil {
+		logEntry.Result("%s", target)
+	}
+	return target, toFuseStatusLog(err, logEntry)
+}
+
+func (fs *ControlFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Create(name, flags, mode, context)
+	}
+
+	logEntry := StartOperation("Create", name)
+	defer logEntry.FinishOperation()
+
+	parentName, baseName := splitControlPath(relName)
+	node, err := fs.lookup(parentName)
+	if err != nil {
+		return nil, toFuseStatusLog(err, logEntry)
+	}
+	dir, ok := node.(ControlCreatableDir)
+	if !ok {
+		return nil, toFuseStatusLog(ErrNotPermitted, logEntry)
+	}
+
+	openFlags := FileOpenFlags(flags) | O_CREATE | O_TRUNC
+	if !openFlags.Contains(O_RDWR) {
+		openFlags |= O_WRONLY
+	}
+	file, err := dir.Create(baseName, openFlags, os.FileMode(mode).Perm(), logEntry)
+	if err == nil {
+		logEntry.Result("%s", file)
+	}
+	return file, toFuseStatusLog(err, logEntry)
+}
+
+func (fs *ControlFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.notPermitted("Mkdir", name)
+	}
+	return fs.FileSystem.Mkdir(name, mode, context)
+}
+
+func (fs *ControlFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.notPermitted("Mknod", name)
+	}
+	return fs.FileSystem.Mknod(name, mode, dev, context)
+}
+
+func (fs *ControlFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	_, oldIsControl := controlPath(oldName)
+	_, newIsControl := controlPath(newName)
+	if oldIsControl || newIsControl {
+		return fs.notPermitted("Rename", formatArgsListForLog(oldName, newName))
+	}
+	return fs.FileSystem.Rename(oldName, newName, context)
+}
+
+func (fs *ControlFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	_, oldIsControl := controlPath(oldName)
+	_, newIsControl := controlPath(newName)
+	if oldIsControl || newIsControl {
+		return fs.notPermitted("Link", formatArgsListForLog(oldName, newName))
+	}
+	return fs.FileSystem.Link(oldName, newName, context)
+}
+
+func (fs *ControlFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(linkName); ok {
+		return fs.notPermitted("Symlink", linkName)
+	}
+	return fs.FileSystem.Symlink(value, linkName, context)
+}
+
+func (fs *ControlFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.notPermitted("Rmdir", name)
+	}
+	return fs.FileSystem.Rmdir(name, context)
+}
+
+func (fs *ControlFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Unlink(name, context)
+	}
+
+	logEntry := StartOperation("Unlink", name)
+	defer logEntry.FinishOperation()
+
+	parentName, baseName := splitControlPath(relName)
+	node, err := fs.lookup(parentName)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	dir, ok := node.(ControlRemovableDir)
+	if !ok || relName == "" {
+		return toFuseStatusLog(ErrNotPermitted, logEntry)
+	}
+	return toFuseStatusLog(dir.Remove(baseName), logEntry)
+}
+
+func (fs *ControlFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.ignoreAttrChange("Chmod", name)
+	}
+	return fs.FileSystem.Chmod(name, mode, context)
+}
+
+func (fs *ControlFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.ignoreAttrChange("Chown", name)
+	}
+	return fs.FileSystem.Chown(name, uid, gid, context)
+}
+
+func (fs *ControlFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	relName, ok := controlPath(name)
+	if !ok {
+		return fs.FileSystem.Truncate(name, size, context)
+	}
+
+	logEntry := StartOperation("Truncate", formatArgsListForLog(name, size))
+	defer logEntry.FinishOperation()
+
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	file, ok := node.(ControlTruncatableFile)
+	if !ok {
+		return toFuseStatusLog(ErrNotPermitted, logEntry)
+	}
+	return toFuseStatusLog(file.Truncate(size), logEntry)
+}
+
+func (fs *ControlFileSystem) Utimens(name string, Atime *time.Time, Mtime *time.Time, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.ignoreAttrChange("Utimens", name)
+	}
+	return fs.FileSystem.Utimens(name, Atime, Mtime, context)
+}
+
+func (fs *ControlFileSystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	if _, ok := controlPath(name); ok {
+		return nil, fuse.ENOSYS
+	}
+	return fs.FileSystem.GetXAttr(name, attribute, context)
+}
+
+func (fs *ControlFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	if _, ok := controlPath(name); ok {
+		return nil, fuse.ENOSYS
+	}
+	return fs.FileSystem.ListXAttr(name, context)
+}
+
+func (fs *ControlFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.notPermitted("RemoveXAttr", name)
+	}
+	return fs.FileSystem.RemoveXAttr(name, attr, context)
+}
+
+func (fs *ControlFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	if _, ok := controlPath(name); ok {
+		return fs.notPermitted("SetXAttr", name)
+	}
+	return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+}
+
+func (fs *ControlFileSystem) StatFs(name string) *fuse.StatfsOut {
+	if _, ok := controlPath(name); ok {
+		return fs.FileSystem.StatFs("")
+	}
+	return fs.FileSystem.StatFs(name)
+}
+
+// ignoreAttrChange pretends to change the attributes of a writable node, since tools that copy
+// files often try to set them, and fail if they can't. Read-only nodes can't be changed.
+func (fs *ControlFileSystem) ignoreAttrChange(operation, name string) fuse.Status {
+	logEntry := StartOperation(operation, name)
+	defer logEntry.FinishOperation()
+
+	relName, _ := controlPath(name)
+	node, err := fs.lookup(relName)
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	attr, err := node.GetAttr()
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	if attr.Mode&0200 == 0 {
+		return toFuseStatusLog(ErrNotPermitted, logEntry)
+	}
+	logEntry.Result("ignored")
+	return toFuseStatusLog(OK, logEntry)
+}
+
+// notPermitted logs and returns the status for an operation that's not supported in the
+// control directory.
+func (fs *ControlFileSystem) notPermitted(operation, name string) fuse.Status {
+	logEntry := StartOperation(operation, name)
+	defer logEntry.FinishOperation()
+	return toFuseStatusLog(ErrNotPermitted, logEntry)
+}
+
+// staticControlDir is a ControlDir with a fixed set of children.
+type staticControlDir struct {
+	children map[string]ControlNode
+}
+
+func newStaticControlDir(children map[string]ControlNode) *staticControlDir {
+	return &staticControlDir{children}
+}
+
+func (d *staticControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *staticControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	entries := make([]fuse.DirEntry, 0, len(d.children))
+	for name, child := range d.children {
+		attr, err := child.GetAttr()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: attr.Mode,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *staticControlDir) Child(name string) (ControlNode, error) {
+	if child, ok := d.children[name]; ok {
+		return child, nil
+	}
+	return nil, syscall.ENOENT
+}
+
+type dirEntriesByName []fuse.DirEntry
+
+func (s dirEntriesByName) Len() int           { return len(s) }
+func (s dirEntriesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s dirEntriesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// dataControlFile is a read-only ControlFile with fixed contents.
+type dataControlFile string
+
+func (f dataControlFile) GetAttr() (*fuse.Attr, error) {
+	return newControlFileAttr(0444, len(f)), nil
+}
+
+func (f dataControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	return newReadOnlyDataFile([]byte(f), flags)
+}
+
+// newControlDirAttr returns the attributes of a read-only virtual directory.
+func newControlDirAttr() *fuse.Attr {
+	return newControlAttr(fuse.S_IFDIR|0555, 0)
+}
+
+// newControlFileAttr returns the attributes of a virtual regular file.
+func newControlFileAttr(perms uint32, size int) *fuse.Attr {
+	return newControlAttr(fuse.S_IFREG|perms, size)
+}
+
+func newControlAttr(mode uint32, size int) *fuse.Attr {
+	now := uint64(time.Now().Unix())
+	return &fuse.Attr{
+		Mode:  mode,
+		Size:  uint64(size),
+		Mtime: now,
+		Atime: now,
+		Ctime: now,
+	}
+}
+
+// newReadOnlyDataFile returns a nodefs.File that reads data. Direct IO is used since the contents
+// are generated when opened, so the size reported by GetAttr may be stale.
+func newReadOnlyDataFile(data []byte, flags FileOpenFlags) (nodefs.File, error) {
+	if flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+	return &nodefs.WithFlags{
+		File:      nodefs.NewReadOnlyFile(nodefs.NewDataFile(data)),
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, nil
+}
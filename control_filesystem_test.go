@@ -0,0 +1,156 @@
+package adbfs
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlPath(t *testing.T) {
+	for _, test := range []struct {
+		Name      string
+		Expected  string
+		IsControl bool
+	}{
+		{"", "", false},
+		{"foo", "", false},
+		{".adbfsx", "", false},
+		{"foo/.adbfs", "", false},
+		{".adbfs", "", true},
+		{"/.adbfs/", "", true},
+		{".adbfs/props/ro.foo", "props/ro.foo", true},
+	} {
+		relName, ok := controlPath(test.Name)
+		assert.Equal(t, test.IsControl, ok, test.Name)
+		assert.Equal(t, test.Expected, relName, test.Name)
+	}
+}
+
+func TestSplitControlPath(t *testing.T) {
+	parent, base := splitControlPath("install")
+	assert.Equal(t, "", parent)
+	assert.Equal(t, "install", base)
+
+	parent, base = splitControlPath("install/app.apk")
+	assert.Equal(t, "install", parent)
+	assert.Equal(t, "app.apk", base)
+}
+
+func TestControlFileSystem_PassesThrough(t *testing.T) {
+	delegate := newRecordingFileSystem()
+	fs := NewControlFileSystem(delegate, ControlConfig{})
+
+	fs.GetAttr("foo/bar", nil)
+	fs.Mkdir("foo/.adbfs", 0755, nil)
+	assert.Equal(t, []string{"foo/bar", "foo/.adbfs"}, delegate.names)
+}
+
+func TestControlFileSystem_RootListing(t *testing.T) {
+	delegate := newRecordingFileSystem()
+
+	entries, status := NewControlFileSystem(delegate, ControlConfig{}).OpenDir("", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{{Name: "foo", Mode: fuse.S_IFREG}}, entries)
+
+	entries, status = NewControlFileSystem(delegate, ControlConfig{Visible: true}).OpenDir("", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "foo", Mode: fuse.S_IFREG},
+		{Name: ".adbfs", Mode: fuse.S_IFDIR},
+	}, entries)
+}
+
+func TestControlFileSystem_HiddenDirAccessible(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{})
+
+	attr, status := fs.GetAttr(".adbfs", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.True(t, attr.IsDir())
+
+	entries, status := fs.OpenDir(".adbfs", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "install", Mode: fuse.S_IFDIR | 0755},
+		{Name: "packages", Mode: fuse.S_IFDIR | 0555},
+		{Name: "props", Mode: fuse.S_IFDIR | 0555},
+		{Name: "screenshot.png", Mode: fuse.S_IFREG | 0444},
+		{Name: "search", Mode: fuse.S_IFDIR | 0555},
+		{Name: "settings", Mode: fuse.S_IFDIR | 0555},
+	}, entries)
+
+	_, status = fs.GetAttr(".adbfs/foo", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
+
+func TestControlFileSystem_ReadOnly(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{})
+
+	assert.Equal(t, fuse.EPERM, fs.Mkdir(".adbfs/foo", 0755, nil))
+	assert.Equal(t, fuse.EPERM, fs.Rmdir(".adbfs", nil))
+	assert.Equal(t, fuse.EPERM, fs.Rename("foo", ".adbfs/foo", nil))
+	assert.Equal(t, fuse.Status(syscall.EACCES), fs.Access(".adbfs", fuse.W_OK, nil))
+	assert.Equal(t, fuse.OK, fs.Access(".adbfs", fuse.R_OK|fuse.X_OK, nil))
+}
+
+func TestControlFileSystem_SharesClient(t *testing.T) {
+	var clients int
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		// Expire immediately, so every lookup runs getprop.
+		PropsTtl: time.Nanosecond,
+		ClientFactory: func() DeviceClient {
+			clients++
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					return "[ro.product.model]: [Nexus 5]\r\n", nil
+				},
+			}
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, status := fs.GetAttr(".adbfs/props/ro.product.model", nil)
+		assert.Equal(t, fuse.OK, status)
+	}
+	assert.Equal(t, 1, clients)
+}
+
+func TestControlFileSystem_Props(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					assert.Equal(t, "getprop", cmd)
+					return "[ro.product.model]: [Nexus 5]\r\n[ro.build.version.sdk]: [23]\r\n", nil
+				},
+			}
+		},
+	})
+
+	entries, status := fs.OpenDir(".adbfs/props", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "ro.build.version.sdk", Mode: fuse.S_IFREG},
+		{Name: "ro.product.model", Mode: fuse.S_IFREG},
+	}, entries)
+
+	attr, status := fs.GetAttr(".adbfs/props/ro.product.model", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, uint64(len("Nexus 5\n")), attr.Size)
+
+	file, status := fs.Open(".adbfs/props/ro.product.model", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	buf := make([]byte, 64)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "Nexus 5\n", string(data))
+
+	_, status = fs.Open(".adbfs/props/ro.product.model", uint32(O_WRONLY), nil)
+	assert.Equal(t, fuse.EPERM, status)
+
+	_, status = fs.GetAttr(".adbfs/props/ro.foo", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
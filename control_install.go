@@ -0,0 +1,348 @@
+package adbfs
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+)
+
+const (
+	// Suffix of the files that report the result of installing an APK.
+	InstallResultSuffix = ".result"
+
+	installPendingResult = "Installing...\n"
+)
+
+/*
+installControlDir is a ControlDir that installs APKs written to it.
+
+APKs are buffered in memory while they're written. When the last writable handle to an APK is
+released, and it was modified since it was created, it is pushed to ShellTempDir and installed with
+pm install -r. Read-only handles never start an install, so file managers reading the APK back don't
+install it again or install it before it's complete. Until the install finishes, the APK
+is still listed and its result file reads installPendingResult. Afterwards the APK disappears and
+the result file contains the output of pm install.
+*/
+type installControlDir struct {
+	clientFactory DeviceClientFactory
+	readOnly      bool
+	serial        string
+	handlers      []string
+
+	lock    sync.Mutex
+	uploads map[string]*apkUpload
+	results map[string]string
+
+	// Tracks running installs, so tests can wait for them.
+	installs sync.WaitGroup
+}
+
+func newInstallControlDir(config ControlConfig) *installControlDir {
+	return &installControlDir{
+		clientFactory: config.ClientFactory,
+		readOnly:      config.ReadOnly,
+		serial:        config.DeviceSerial,
+		handlers:      config.OnInstallHandlers,
+		uploads:       make(map[string]*apkUpload),
+		results:       make(map[string]string),
+	}
+}
+
+func (d *installControlDir) GetAttr() (*fuse.Attr, error) {
+	if d.readOnly {
+		return newControlDirAttr(), nil
+	}
+	return newControlAttr(fuse.S_IFDIR|0755, 0), nil
+}
+
+func (d *installControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entries := make([]fuse.DirEntry, 0, len(d.uploads)+len(d.results))
+	for name := range d.uploads {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	for name := range d.results {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *installControlDir) Child(name string) (ControlNode, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if upload, ok := d.uploads[name]; ok {
+		return upload, nil
+	}
+	if result, ok := d.results[name]; ok {
+		return dataControlFile(result), nil
+	}
+	return nil, syscall.ENOENT
+}
+
+func (d *installControlDir) Create(name string, flags FileOpenFlags, perms os.FileMode, logEntry *LogEntry) (nodefs.File, error) {
+	// Names starting with ._ are AppleDouble files that OS X writes alongside copied files.
+	if d.readOnly || path.Ext(name) != ".apk" || strings.HasPrefix(name, "._") {
+		return nil, ErrNotPermitted
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if upload, ok := d.uploads[name]; ok && upload.installing {
+		return nil, syscall.EBUSY
+	}
+	upload := &apkUpload{
+		dir:  d,
+		name: name,
+	}
+	d.uploads[name] = upload
+	delete(d.results, name+InstallResultSuffix)
+	return upload.newFile(flags), nil
+}
+
+// Remove deletes result files, and APKs that haven't started installing yet.
+func (d *installControlDir) Remove(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if upload, ok := d.uploads[name]; ok {
+		if upload.installing {
+			return syscall.EBUSY
+		}
+		delete(d.uploads, name)
+		return nil
+	}
+	if _, ok := d.results[name]; ok {
+		delete(d.results, name)
+		return nil
+	}
+	return syscall.ENOENT
+}
+
+// release is called when a handle to upload is released, and starts installing it if it was the
+// last writable one and the APK was modified. Must be called with the lock held.
+func (d *installControlDir) release(upload *apkUpload, writable bool) {
+	if !writable {
+		return
+	}
+	upload.writers--
+	if upload.writers > 0 || !upload.modified || upload.installing || d.uploads[upload.name] != upload {
+		return
+	}
+
+	upload.installing = true
+	d.results[upload.name+InstallResultSuffix] = installPendingResult
+	d.installs.Add(1)
+	go d.install(upload)
+}
+
+func (d *installControlDir) install(upload *apkUpload) {
+	defer d.installs.Done()
+
+	logEntry := StartOperation("Install", upload.name)
+	defer logEntry.FinishOperation()
+
+	// Nothing else can modify the data once installing is set.
+	result, err := installApk(d.clientFactory(), upload.data, logEntry)
+	if err != nil {
+		logEntry.Error(err)
+		result = fmt.Sprintf("Failure [%s]", err)
+	}
+	logEntry.Result("%s", result)
+
+	d.lock.Lock()
+	if d.uploads[upload.name] == upload {
+		delete(d.uploads, upload.name)
+	}
+	d.results[upload.name+InstallResultSuffix] = result + "\n"
+	d.lock.Unlock()
+
+	cli.FireHandlers(d.handlers, map[string]string{
+		cli.SerialHandlerVar:        d.serial,
+		cli.ApkHandlerVar:           upload.name,
+		cli.InstallResultHandlerVar: result,
+	})
+}
+
+// installApk pushes data to a temp file on the device, installs it, and returns the output of
+// pm install.
+func installApk(client DeviceClient, data []byte, logEntry *LogEntry) (string, error) {
+	tempPath := path.Join(ShellTempDir, fmt.Sprintf("adbfs-install-%d.apk", rand.Int63()))
+
+	w, err := client.OpenWrite(tempPath, 0644, time.Now(), logEntry)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(data)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	defer client.RunCommand("rm", "-f", tempPath)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := client.RunScheduledCommand(CommandOperation, logEntry, "pm", "install", "-r", tempPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// apkUpload is a ControlFile that holds an APK being written to an installControlDir.
+// It's protected by the lock of its dir.
+type apkUpload struct {
+	dir  *installControlDir
+	name string
+	data []byte
+	// Number of open handles that can write to data.
+	writers int
+	// True once data has been written or truncated.
+	modified   bool
+	installing bool
+}
+
+func (u *apkUpload) GetAttr() (*fuse.Attr, error) {
+	u.dir.lock.Lock()
+	defer u.dir.lock.Unlock()
+	return u.getAttrLocked(), nil
+}
+
+func (u *apkUpload) getAttrLocked() *fuse.Attr {
+	if u.installing {
+		return newControlFileAttr(0444, len(u.data))
+	}
+	return newControlFileAttr(0644, len(u.data))
+}
+
+func (u *apkUpload) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	u.dir.lock.Lock()
+	defer u.dir.lock.Unlock()
+
+	if u.installing && flags.Contains(O_RDWR|O_WRONLY|O_TRUNC|O_APPEND) {
+		return nil, syscall.EBUSY
+	}
+	if flags.Contains(O_TRUNC) {
+		u.data = nil
+		u.modified = true
+	}
+	return u.newFile(flags), nil
+}
+
+func (u *apkUpload) Truncate(size uint64) error {
+	u.dir.lock.Lock()
+	defer u.dir.lock.Unlock()
+	return u.truncateLocked(size)
+}
+
+func (u *apkUpload) truncateLocked(size uint64) error {
+	if u.installing {
+		return syscall.EBUSY
+	}
+	if size <= uint64(len(u.data)) {
+		u.data = u.data[:size]
+	} else {
+		u.data = append(u.data, make([]byte, size-uint64(len(u.data)))...)
+	}
+	u.modified = true
+	return nil
+}
+
+// newFile returns a handle to u. Must be called with the lock held.
+func (u *apkUpload) newFile(flags FileOpenFlags) nodefs.File {
+	if flags.CanWrite() {
+		u.writers++
+	}
+	return &installFile{
+		File:   nodefs.NewDefaultFile(),
+		upload: u,
+		flags:  flags,
+	}
+}
+
+// installFile is a nodefs.File that reads and writes an apkUpload.
+type installFile struct {
+	nodefs.File
+	upload *apkUpload
+	flags  FileOpenFlags
+}
+
+func (f *installFile) String() string {
+	return fmt.Sprintf("installFile(%s, %s)", f.upload.name, f.flags)
+}
+
+func (f *installFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *installFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.upload.dir.lock.Lock()
+	defer f.upload.dir.lock.Unlock()
+
+	data := f.upload.data
+	if off >= int64(len(data)) {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	n := copy(buf, data[off:])
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+func (f *installFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	if !f.flags.CanWrite() {
+		return 0, fuse.EBADF
+	}
+
+	f.upload.dir.lock.Lock()
+	defer f.upload.dir.lock.Unlock()
+
+	u := f.upload
+	if u.installing {
+		return 0, fuse.Status(syscall.EBUSY)
+	}
+	if end := off + int64(len(data)); end > int64(len(u.data)) {
+		u.truncateLocked(uint64(end))
+	}
+	n := copy(u.data[off:], data)
+	u.modified = true
+	return uint32(n), fuse.OK
+}
+
+func (f *installFile) Truncate(size uint64) fuse.Status {
+	return fuse.Status(toErrno(f.upload.Truncate(size)))
+}
+
+func (f *installFile) GetAttr(out *fuse.Attr) fuse.Status {
+	attr, _ := f.upload.GetAttr()
+	*out = *attr
+	return fuse.OK
+}
+
+func (f *installFile) Flush() fuse.Status {
+	return fuse.OK
+}
+
+func (f *installFile) Release() {
+	f.upload.dir.lock.Lock()
+	defer f.upload.dir.lock.Unlock()
+	f.upload.dir.release(f.upload, f.flags.CanWrite())
+}
@@ -0,0 +1,135 @@
+package adbfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallControlDir_Install(t *testing.T) {
+	var pushed bytes.Buffer
+	var pushedPath string
+	var commands []string
+	client := &delegateDeviceClient{
+		openWrite: func(path string, mode os.FileMode, mtime time.Time) (io.WriteCloser, error) {
+			pushedPath = path
+			return noopWriteCloser{w: &pushed}, nil
+		},
+		runCommand: recordCommands(&commands, func(cmd string, args []string) (string, error) {
+			if cmd == "pm" {
+				return "Success\r\n", nil
+			}
+			return "", nil
+		}),
+	}
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ClientFactory: func() DeviceClient { return client },
+	})
+	dir := fs.root.(*staticControlDir).children["install"].(*installControlDir)
+
+	file, status := fs.Create(".adbfs/install/app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.OK, status)
+	n, status := file.Write([]byte("apk"), 0)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, uint32(3), n)
+
+	attr, status := fs.GetAttr(".adbfs/install/app.apk", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, uint64(3), attr.Size)
+	assert.Equal(t, fuse.OK, fs.Chmod(".adbfs/install/app.apk", 0600, nil))
+
+	file.Release()
+	dir.installs.Wait()
+
+	assert.Equal(t, "apk", pushed.String())
+	assert.True(t, strings.HasPrefix(pushedPath, ShellTempDir+"/adbfs-install-"), pushedPath)
+	assert.Equal(t, []string{
+		"pm install -r " + pushedPath,
+		"rm -f " + pushedPath,
+	}, commands)
+
+	entries, status := fs.OpenDir(".adbfs/install", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{{Name: "app.apk.result", Mode: fuse.S_IFREG}}, entries)
+
+	file, status = fs.Open(".adbfs/install/app.apk.result", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	buf := make([]byte, 64)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "Success\n", string(data))
+
+	assert.Equal(t, fuse.OK, fs.Unlink(".adbfs/install/app.apk.result", nil))
+	_, status = fs.GetAttr(".adbfs/install/app.apk.result", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
+
+func TestInstallControlDir_RemoveBeforeRelease(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{})
+	dir := fs.root.(*staticControlDir).children["install"].(*installControlDir)
+
+	file, status := fs.Create(".adbfs/install/app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, fuse.OK, fs.Unlink(".adbfs/install/app.apk", nil))
+
+	// The client factory is nil, so this would panic if it tried to install.
+	file.Release()
+	dir.installs.Wait()
+
+	entries, status := fs.OpenDir(".adbfs/install", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Empty(t, entries)
+}
+
+func TestInstallControlDir_ReadersDontInstall(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{})
+	dir := fs.root.(*staticControlDir).children["install"].(*installControlDir)
+
+	writer, status := fs.Create(".adbfs/install/app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.OK, status)
+	writer.Write([]byte("ap"), 0)
+
+	// The client factory is nil, so these would panic if they tried to install.
+	reader, status := fs.Open(".adbfs/install/app.apk", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	reader.Release()
+	dir.installs.Wait()
+
+	unmodified, status := fs.Create(".adbfs/install/empty.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.OK, status)
+	unmodified.Release()
+	dir.installs.Wait()
+
+	entries, status := fs.OpenDir(".adbfs/install", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "app.apk", Mode: fuse.S_IFREG},
+		{Name: "empty.apk", Mode: fuse.S_IFREG},
+	}, entries)
+}
+
+func TestInstallControlDir_NotPermitted(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{})
+	_, status := fs.Create(".adbfs/install/app.txt", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.EPERM, status)
+	_, status = fs.Create(".adbfs/install/._app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.EPERM, status)
+	_, status = fs.Create(".adbfs/props/app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.EPERM, status)
+	assert.Equal(t, fuse.EPERM, fs.Unlink(".adbfs/props", nil))
+
+	fs = NewControlFileSystem(newRecordingFileSystem(), ControlConfig{ReadOnly: true})
+	_, status = fs.Create(".adbfs/install/app.apk", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.EPERM, status)
+	attr, status := fs.GetAttr(".adbfs/install", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, uint32(fuse.S_IFDIR|0555), attr.Mode)
+	assert.Equal(t, fuse.EPERM, fs.Chmod(".adbfs/install", 0777, nil))
+}
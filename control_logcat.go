@@ -0,0 +1,217 @@
+package adbfs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+)
+
+const (
+	// Maximum number of bytes of logcat output kept in memory for each logcat file.
+	LogcatBufferSize = 4 * 1024 * 1024
+
+	// Discarded logcat output is read as blank lines, so text tools don't treat it as binary.
+	logcatDiscardedFill = '\n'
+
+	logcatReadChunkSize = 32 * 1024
+)
+
+// Names of the logcat files in the control directory, mapped to the arguments passed to logcat.
+var logcatControlFiles = map[string]string{
+	"logcat":        "",
+	"logcat-all":    "-b all",
+	"logcat-main":   "-b main",
+	"logcat-system": "-b system",
+	"logcat-crash":  "-b crash",
+	"logcat-events": "-b events",
+	"logcat-radio":  "-b radio",
+}
+
+func addLogcatControlFiles(children map[string]ControlNode, openStream ShellStreamOpener) {
+	for name, args := range logcatControlFiles {
+		command := "logcat"
+		if args != "" {
+			command += " " + args
+		}
+		children[name] = &logcatControlFile{
+			stream: newLogcatStream(command, openStream),
+		}
+	}
+}
+
+/*
+logcatStream runs logcat on the device while any handles to it are open, and keeps the most recent
+output in a RingBuffer. The buffer is only allocated while the stream is running, and is discarded
+when it stops, since logcat starts by dumping the device's entire log again.
+*/
+type logcatStream struct {
+	command    string
+	openStream ShellStreamOpener
+
+	lock sync.Mutex
+	// Nil when the stream isn't running.
+	buffer   *RingBuffer
+	refCount int
+	stream   io.ReadCloser
+}
+
+func newLogcatStream(command string, openStream ShellStreamOpener) *logcatStream {
+	return &logcatStream{
+		command:    command,
+		openStream: openStream,
+	}
+}
+
+// Acquire starts logcat if it isn't already running.
+func (s *logcatStream) Acquire() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stream == nil {
+		stream, err := s.openStream(s.command)
+		if err != nil {
+			return err
+		}
+		cli.Log.Debugln("started streaming", s.command)
+		s.stream = stream
+		s.buffer = NewRingBuffer(LogcatBufferSize)
+		go s.copyToBuffer(stream)
+	}
+	s.refCount++
+	return nil
+}
+
+// Release stops logcat when the last handle is released.
+func (s *logcatStream) Release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.refCount--
+	if s.refCount <= 0 {
+		s.stop()
+	}
+}
+
+// Close stops logcat even if handles are still open, e.g. because the device is gone.
+// Open handles read an empty file.
+func (s *logcatStream) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stop()
+}
+
+// stop must be called with lock held.
+func (s *logcatStream) stop() {
+	s.refCount = 0
+	if s.stream != nil {
+		cli.Log.Debugln("stopped streaming", s.command)
+		s.stream.Close()
+		s.stream = nil
+		s.buffer = nil
+	}
+}
+
+func (s *logcatStream) copyToBuffer(stream io.Reader) {
+	buf := make([]byte, logcatReadChunkSize)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			s.lock.Lock()
+			if s.stream == stream {
+				s.buffer.Write(buf[:n])
+			}
+			s.lock.Unlock()
+		}
+		if err != nil {
+			if err != io.EOF {
+				cli.Log.Debugf("%s stream ended: %s", s.command, err)
+			}
+			return
+		}
+	}
+}
+
+// Size returns the total number of bytes read from logcat since it was started, or 0 if it's not
+// running.
+func (s *logcatStream) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.buffer == nil {
+		return 0
+	}
+	return s.buffer.Size()
+}
+
+func (s *logcatStream) ReadAt(buf []byte, off int64) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.buffer == nil {
+		return 0
+	}
+	return s.buffer.ReadAt(buf, off, logcatDiscardedFill)
+}
+
+// logcatControlFile is a ControlFile whose contents grow as logcat produces output.
+type logcatControlFile struct {
+	stream *logcatStream
+}
+
+func (f *logcatControlFile) GetAttr() (*fuse.Attr, error) {
+	return newControlFileAttr(0444, int(f.stream.Size())), nil
+}
+
+func (f *logcatControlFile) close() {
+	f.stream.Close()
+}
+
+func (f *logcatControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	if flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+	if err := f.stream.Acquire(); err != nil {
+		return nil, err
+	}
+
+	// Direct IO, since the kernel would otherwise stop reading at the size it last saw.
+	return &nodefs.WithFlags{
+		File: &logcatFile{
+			File:   nodefs.NewDefaultFile(),
+			stream: f.stream,
+		},
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, nil
+}
+
+// logcatFile is a nodefs.File that reads from a logcatStream.
+// Reading at the end of the stream returns EOF instead of blocking, so tools like tail -f work.
+type logcatFile struct {
+	nodefs.File
+	stream *logcatStream
+}
+
+func (f *logcatFile) String() string {
+	return fmt.Sprintf("logcatFile(%s)", f.stream.command)
+}
+
+func (f *logcatFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *logcatFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	n := f.stream.ReadAt(buf, off)
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+func (f *logcatFile) GetAttr(out *fuse.Attr) fuse.Status {
+	attr, _ := (&logcatControlFile{f.stream}).GetAttr()
+	*out = *attr
+	return fuse.OK
+}
+
+func (f *logcatFile) Release() {
+	f.stream.Release()
+}
@@ -0,0 +1,122 @@
+package adbfs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogcatControlFile_StreamsWhileOpen(t *testing.T) {
+	var commands []string
+	var streamWriter *io.PipeWriter
+	file := &logcatControlFile{
+		stream: newLogcatStream("logcat -b crash", func(command string) (io.ReadCloser, error) {
+			commands = append(commands, command)
+			r, w := io.Pipe()
+			streamWriter = w
+			return r, nil
+		}),
+	}
+
+	attr, _ := file.GetAttr()
+	assert.Equal(t, uint64(0), attr.Size)
+
+	f, err := file.Open(O_RDONLY, &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"logcat -b crash"}, commands)
+
+	streamWriter.Write([]byte("hello\n"))
+	streamWriter.Write([]byte("world\n"))
+	waitForLogcatSize(t, file.stream, 12)
+
+	var fileAttr fuse.Attr
+	assert.Equal(t, fuse.OK, f.GetAttr(&fileAttr))
+	assert.Equal(t, uint64(12), fileAttr.Size)
+
+	buf := make([]byte, 100)
+	result, status := f.Read(buf, 6)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "world\n", string(data))
+
+	// Reading past the end is EOF, not blocking.
+	result, status = f.Read(buf, 12)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, 0, result.Size())
+
+	f.Release()
+	_, err = streamWriter.Write([]byte("closed"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestLogcatControlFile_SharesStream(t *testing.T) {
+	var opens int
+	file := &logcatControlFile{
+		stream: newLogcatStream("logcat", func(command string) (io.ReadCloser, error) {
+			opens++
+			r, _ := io.Pipe()
+			return r, nil
+		}),
+	}
+
+	// The buffer is only allocated while the stream is running.
+	assert.Nil(t, file.stream.buffer)
+
+	f1, _ := file.Open(O_RDONLY, &LogEntry{})
+	f2, _ := file.Open(O_RDONLY, &LogEntry{})
+	assert.Equal(t, 1, opens)
+	assert.NotNil(t, file.stream.buffer)
+
+	f1.Release()
+	f3, _ := file.Open(O_RDONLY, &LogEntry{})
+	assert.Equal(t, 1, opens)
+
+	f2.Release()
+	f3.Release()
+	assert.Nil(t, file.stream.buffer)
+	file.Open(O_RDONLY, &LogEntry{})
+	assert.Equal(t, 2, opens)
+}
+
+func TestLogcatControlFile_Close(t *testing.T) {
+	var streamWriter *io.PipeWriter
+	file := &logcatControlFile{
+		stream: newLogcatStream("logcat", func(command string) (io.ReadCloser, error) {
+			r, w := io.Pipe()
+			streamWriter = w
+			return r, nil
+		}),
+	}
+
+	f, _ := file.Open(O_RDONLY, &LogEntry{})
+	file.close()
+	assert.Nil(t, file.stream.buffer)
+	_, err := streamWriter.Write([]byte("closed"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	// Releasing a handle that was open when the stream was closed doesn't break the next open.
+	f.Release()
+	file.Open(O_RDONLY, &LogEntry{})
+	assert.Equal(t, 1, file.stream.refCount)
+}
+
+func TestLogcatControlFile_ReadOnly(t *testing.T) {
+	file := &logcatControlFile{
+		stream: newLogcatStream("logcat", nil),
+	}
+	_, err := file.Open(O_WRONLY, &LogEntry{})
+	assert.Equal(t, ErrNotPermitted, err)
+}
+
+func waitForLogcatSize(t *testing.T, stream *logcatStream, size int64) {
+	deadline := time.Now().Add(time.Second)
+	for stream.Size() < size {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d bytes, got %d", size, stream.Size())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,213 @@
+package adbfs
+
+import (
+	"bufio"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// How long to cache the list of installed packages and their APK paths.
+const PackagesCacheTtl = 10 * time.Second
+
+// packagesControlDir is a ControlDir that contains a packageControlDir for every installed package.
+type packagesControlDir struct {
+	clientFactory DeviceClientFactory
+	openFiles     *OpenFiles
+	cache         *controlCache
+}
+
+func newPackagesControlDir(clientFactory DeviceClientFactory, openFiles *OpenFiles) *packagesControlDir {
+	return &packagesControlDir{
+		clientFactory: clientFactory,
+		openFiles:     openFiles,
+		cache:         newControlCache(PackagesCacheTtl),
+	}
+}
+
+// packages returns the names of all installed packages.
+func (d *packagesControlDir) packages() (map[string]bool, error) {
+	packages, err := d.cache.GetOrLoad("packages", func() (interface{}, error) {
+		output, err := d.clientFactory().RunCommand("pm", "list", "packages", "-f")
+		if err != nil {
+			return nil, err
+		}
+		return parsePmListPackagesOutput(output), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages.(map[string]bool), nil
+}
+
+// apkPaths returns the device paths of all the APKs for an installed package.
+func (d *packagesControlDir) apkPaths(packageName string) ([]string, error) {
+	paths, err := d.cache.GetOrLoad("path:"+packageName, func() (interface{}, error) {
+		output, err := d.clientFactory().RunCommand("pm", "path", packageName)
+		if err != nil {
+			return nil, err
+		}
+		return parsePmPathOutput(output), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths.([]string), nil
+}
+
+func (d *packagesControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *packagesControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	packages, err := d.packages()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(packages))
+	for name := range packages {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFDIR,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *packagesControlDir) Child(name string) (ControlNode, error) {
+	packages, err := d.packages()
+	if err != nil {
+		return nil, err
+	}
+	if !packages[name] {
+		return nil, syscall.ENOENT
+	}
+	return &packageControlDir{d, name}, nil
+}
+
+// packageControlDir is a ControlDir that contains the APKs of a single package.
+type packageControlDir struct {
+	packages *packagesControlDir
+	name     string
+}
+
+func (d *packageControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *packageControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	paths, err := d.packages.apkPaths(d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, len(paths))
+	for i, apkPath := range paths {
+		entries[i] = fuse.DirEntry{
+			Name: path.Base(apkPath),
+			Mode: fuse.S_IFREG,
+		}
+	}
+	return entries, nil
+}
+
+func (d *packageControlDir) Child(name string) (ControlNode, error) {
+	paths, err := d.packages.apkPaths(d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, apkPath := range paths {
+		if path.Base(apkPath) == name {
+			return &apkControlFile{d.packages, apkPath}, nil
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+// apkControlFile is a read-only ControlFile backed by an APK on the device.
+type apkControlFile struct {
+	packages *packagesControlDir
+	path     string
+}
+
+func (f *apkControlFile) GetAttr() (*fuse.Attr, error) {
+	entry, err := f.packages.clientFactory().Stat(f.path, &LogEntry{})
+	if err != nil {
+		return nil, err
+	}
+
+	attr := newControlFileAttr(0444, int(entry.Size))
+	attr.Mtime = uint64(entry.ModifiedAt.Unix())
+	return attr, nil
+}
+
+func (f *apkControlFile) Open(flags FileOpenFlags, logEntry *LogEntry) (nodefs.File, error) {
+	if flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+
+	openFile, err := f.packages.openFiles.GetOrLoad(f.path, flags, DontSetPerms, logEntry)
+	if err != nil {
+		return nil, err
+	}
+	return NewAdbFile(AdbFileOpenOptions{
+		FileBuffer: openFile,
+		Flags:      flags,
+	}), nil
+}
+
+/*
+parsePmListPackagesOutput parses the output of pm list packages -f and returns the set of
+package names.
+
+Sample output:
+
+	package:/data/app/com.example-1/base.apk=com.example
+	package:/system/priv-app/Settings/Settings.apk=com.android.settings
+*/
+func parsePmListPackagesOutput(output string) map[string]bool {
+	packages := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "package:") {
+			continue
+		}
+
+		// The APK path may contain =, but package names can't.
+		if i := strings.LastIndex(line, "="); i >= 0 {
+			if name := line[i+1:]; isValidControlName(name) {
+				packages[name] = true
+			}
+		}
+	}
+	return packages
+}
+
+/*
+parsePmPathOutput parses the output of pm path and returns the APK paths.
+
+Sample output:
+
+	package:/data/app/com.example-1/base.apk
+	package:/data/app/com.example-1/split_config.arm64_v8a.apk
+*/
+func parsePmPathOutput(output string) (paths []string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package:") {
+			paths = append(paths, strings.TrimPrefix(line, "package:"))
+		}
+	}
+	return paths
+}
@@ -0,0 +1,92 @@
+package adbfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+)
+
+func TestParsePmListPackagesOutput(t *testing.T) {
+	packages := parsePmListPackagesOutput(`package:/data/app/com.example-1/base.apk=com.example
+package:/system/priv-app/Settings/Settings.apk=com.android.settings
+package:/data/app/com.weird=path-1/base.apk=com.weird
+Error: something
+`)
+	assert.Equal(t, map[string]bool{
+		"com.example":          true,
+		"com.android.settings": true,
+		"com.weird":            true,
+	}, packages)
+}
+
+func TestParsePmPathOutput(t *testing.T) {
+	paths := parsePmPathOutput("package:/data/app/com.example-1/base.apk\r\n" +
+		"package:/data/app/com.example-1/split_config.arm64_v8a.apk\r\n")
+	assert.Equal(t, []string{
+		"/data/app/com.example-1/base.apk",
+		"/data/app/com.example-1/split_config.arm64_v8a.apk",
+	}, paths)
+}
+
+func TestPackagesControlDir(t *testing.T) {
+	client := &delegateDeviceClient{
+		runCommand: func(cmd string, args []string) (string, error) {
+			switch strings.Join(args, " ") {
+			case "list packages -f":
+				return "package:/data/app/com.example-1/base.apk=com.example\n", nil
+			case "path com.example":
+				return "package:/data/app/com.example-1/base.apk\npackage:/data/app/com.example-1/split_a.apk\n", nil
+			}
+			t.Fatal("invalid command:", cmd, args)
+			return "", nil
+		},
+		stat: func(path string) (*adb.DirEntry, error) {
+			assert.Equal(t, "/data/app/com.example-1/base.apk", path)
+			return &adb.DirEntry{Name: path, Size: 3, ModifiedAt: time.Unix(100, 0)}, nil
+		},
+		openRead: func(path string) (io.ReadCloser, error) {
+			assert.Equal(t, "/data/app/com.example-1/base.apk", path)
+			return ioutil.NopCloser(bytes.NewBufferString("apk")), nil
+		},
+	}
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ClientFactory: func() DeviceClient { return client },
+	})
+
+	entries, status := fs.OpenDir(".adbfs/packages", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{{Name: "com.example", Mode: fuse.S_IFDIR}}, entries)
+
+	entries, status = fs.OpenDir(".adbfs/packages/com.example", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "base.apk", Mode: fuse.S_IFREG},
+		{Name: "split_a.apk", Mode: fuse.S_IFREG},
+	}, entries)
+
+	_, status = fs.OpenDir(".adbfs/packages/com.other", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+
+	attr, status := fs.GetAttr(".adbfs/packages/com.example/base.apk", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, uint64(3), attr.Size)
+	assert.Equal(t, uint64(100), attr.Mtime)
+
+	file, status := fs.Open(".adbfs/packages/com.example/base.apk", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	buf := make([]byte, 10)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "apk", string(data))
+
+	_, status = fs.Open(".adbfs/packages/com.example/base.apk", uint32(O_RDWR), nil)
+	assert.Equal(t, fuse.EPERM, status)
+}
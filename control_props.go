@@ -0,0 +1,140 @@
+package adbfs
+
+import (
+	"bufio"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+// DevicePropsCache caches the system properties of a device, all loaded by a single getprop call.
+type DevicePropsCache struct {
+	clientFactory DeviceClientFactory
+	cache         *controlCache
+}
+
+func NewDevicePropsCache(clientFactory DeviceClientFactory, ttl time.Duration) *DevicePropsCache {
+	return &DevicePropsCache{
+		clientFactory: clientFactory,
+		cache:         newControlCache(ttl),
+	}
+}
+
+// Props returns all system properties, running getprop if the cached properties have expired.
+func (c *DevicePropsCache) Props() (map[string]string, error) {
+	props, err := c.cache.GetOrLoad("getprop", func() (interface{}, error) {
+		output, err := c.clientFactory().RunCommand("getprop")
+		if err != nil {
+			return nil, err
+		}
+		return parseGetpropOutput(output)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return props.(map[string]string), nil
+}
+
+/*
+parseGetpropOutput parses the output of getprop with no arguments into a map of names to values.
+
+Sample output:
+
+	[dalvik.vm.heapsize]: [512m]
+	[ro.product.model]: [Nexus 5]
+
+Values that contain newlines are continued on the following lines.
+*/
+func parseGetpropOutput(output string) (map[string]string, error) {
+	props := make(map[string]string)
+	var lastName string
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "[") {
+			i := strings.Index(line, "]: [")
+			if i < 0 {
+				return nil, util.Errorf(util.ParseError, "invalid getprop line: %s", line)
+			}
+			lastName = line[1:i]
+			props[lastName] = line[i+len("]: ["):]
+		} else if lastName != "" {
+			// Continuation of a multi-line value.
+			props[lastName] += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, value := range props {
+		props[name] = strings.TrimSuffix(value, "]")
+	}
+	return props, nil
+}
+
+// Clear forgets the cached properties.
+func (c *DevicePropsCache) Clear() {
+	c.cache.Clear()
+}
+
+// propsControlDir is a ControlDir that contains a dataControlFile for every system property.
+type propsControlDir struct {
+	props *DevicePropsCache
+}
+
+func newPropsControlDir(props *DevicePropsCache) *propsControlDir {
+	return &propsControlDir{props}
+}
+
+func (d *propsControlDir) close() {
+	d.props.Clear()
+}
+
+func (d *propsControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *propsControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	props, err := d.props.Props()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(props))
+	for name := range props {
+		if !isValidControlName(name) {
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *propsControlDir) Child(name string) (ControlNode, error) {
+	props, err := d.props.Props()
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := props[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return dataControlFile(value + "\n"), nil
+}
+
+// isValidControlName returns true if name can be used as a file name.
+func isValidControlName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
@@ -0,0 +1,48 @@
+package adbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGetpropOutput(t *testing.T) {
+	props, err := parseGetpropOutput(`[dalvik.vm.heapsize]: [512m]
+[ro.empty]: []
+[ro.multiline]: [line one
+line two]]
+[ro.product.model]: [Nexus 5]
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"dalvik.vm.heapsize": "512m",
+		"ro.empty":           "",
+		"ro.multiline":       "line one\nline two]",
+		"ro.product.model":   "Nexus 5",
+	}, props)
+}
+
+func TestParseGetpropOutputInvalid(t *testing.T) {
+	_, err := parseGetpropOutput("[foo]bar\n")
+	assert.Error(t, err)
+}
+
+func TestDevicePropsCache(t *testing.T) {
+	var calls int
+	cache := NewDevicePropsCache(func() DeviceClient {
+		return &delegateDeviceClient{
+			runCommand: func(cmd string, args []string) (string, error) {
+				calls++
+				return "[foo]: [bar]\n", nil
+			},
+		}
+	}, time.Minute)
+
+	props, err := cache.Props()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar"}, props)
+
+	cache.Props()
+	assert.Equal(t, 1, calls)
+}
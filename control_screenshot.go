@@ -0,0 +1,113 @@
+package adbfs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+const ScreenshotControlFileName = "screenshot.png"
+
+// The PNG signature after the shell of an older device has converted every \n to \r\n.
+var pngSignatureWithCRLF = []byte("\x89PNG\r\r\n\x1a\r\n")
+
+// screenshotControlFile is a ControlFile that takes a new screenshot with screencap every time it's
+// opened.
+type screenshotControlFile struct {
+	clientFactory DeviceClientFactory
+}
+
+func (f *screenshotControlFile) GetAttr() (*fuse.Attr, error) {
+	// The size isn't known until a screenshot is taken.
+	return newControlFileAttr(0444, 0), nil
+}
+
+func (f *screenshotControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	if flags.Contains(O_RDWR | O_WRONLY | O_CREATE | O_TRUNC | O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+
+	// Direct IO, since the size reported before opening is always 0.
+	return &nodefs.WithFlags{
+		File: &screenshotFile{
+			File:          nodefs.NewDefaultFile(),
+			clientFactory: f.clientFactory,
+		},
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, nil
+}
+
+/*
+screenshotFile is a nodefs.File that holds a single screenshot.
+
+The screenshot is taken the first time the file is read or stat'd, not when it's opened, so errors
+are reported to the reader.
+*/
+type screenshotFile struct {
+	nodefs.File
+	clientFactory DeviceClientFactory
+
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func (f *screenshotFile) String() string {
+	return fmt.Sprintf("screenshotFile(%d bytes)", len(f.data))
+}
+
+func (f *screenshotFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *screenshotFile) capture() ([]byte, error) {
+	f.once.Do(func() {
+		var output string
+		output, f.err = f.clientFactory().RunScheduledCommand(CommandOperation, nil, "screencap", "-p")
+		if f.err == nil {
+			f.data = fixScreencapOutput([]byte(output))
+		}
+	})
+	return f.data, f.err
+}
+
+func (f *screenshotFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	logEntry := StartFileOperation("Read", ScreenshotControlFileName, formatArgsListForLog(buf, off))
+	defer logEntry.FinishOperation()
+
+	data, err := f.capture()
+	if err != nil {
+		return readError(err, logEntry)
+	}
+
+	var n int
+	if off < int64(len(data)) {
+		n = copy(buf, data[off:])
+	}
+	logEntry.Result("read %d bytes", n)
+	return fuse.ReadResultData(buf[:n]), toFuseStatusLog(OK, logEntry)
+}
+
+func (f *screenshotFile) GetAttr(out *fuse.Attr) fuse.Status {
+	logEntry := StartFileOperation("GetAttr", ScreenshotControlFileName, "")
+	defer logEntry.FinishOperation()
+
+	data, err := f.capture()
+	if err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	*out = *newControlFileAttr(0444, len(data))
+	return toFuseStatusLog(OK, logEntry)
+}
+
+// fixScreencapOutput undoes the \n to \r\n conversion performed by the shell on older devices,
+// which corrupts binary output.
+func fixScreencapOutput(data []byte) []byte {
+	if bytes.HasPrefix(data, pngSignatureWithCRLF) {
+		return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	}
+	return data
+}
@@ -0,0 +1,73 @@
+package adbfs
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixScreencapOutput(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\r\n\n"
+	assert.Equal(t, png, string(fixScreencapOutput([]byte(png))))
+	assert.Equal(t, png, string(fixScreencapOutput([]byte("\x89PNG\r\r\n\x1a\r\n\x00\r\r\n\r\n"))))
+}
+
+func TestControlFileSystem_Screenshot(t *testing.T) {
+	var captures int
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					assert.Equal(t, "screencap", cmd)
+					assert.Equal(t, []string{"-p"}, args)
+					captures++
+					return "\x89PNG\r\n\x1a\nimage", nil
+				},
+			}
+		},
+	})
+
+	file, status := fs.Open(".adbfs/screenshot.png", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, 0, captures)
+
+	var attr fuse.Attr
+	assert.Equal(t, fuse.OK, file.GetAttr(&attr))
+	assert.Equal(t, uint64(13), attr.Size)
+
+	buf := make([]byte, 64)
+	result, status := file.Read(buf, 8)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "image", string(data))
+	assert.Equal(t, 1, captures)
+
+	file, status = fs.Open(".adbfs/screenshot.png", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	file.Read(buf, 0)
+	assert.Equal(t, 2, captures)
+
+	_, status = fs.Open(".adbfs/screenshot.png", uint32(O_WRONLY), nil)
+	assert.Equal(t, fuse.EPERM, status)
+}
+
+func TestControlFileSystem_ScreenshotError(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					return "", syscall.ENODEV
+				},
+			}
+		},
+	})
+
+	file, status := fs.Open(".adbfs/screenshot.png", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	buf := make([]byte, 64)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.Status(syscall.ENODEV), status)
+	assert.Equal(t, 0, result.Size())
+}
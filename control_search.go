@@ -0,0 +1,298 @@
+package adbfs
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+const (
+	// How long to cache the results of a search.
+	SearchCacheTtl = 30 * time.Second
+
+	// Maximum number of matches listed by a search. find is stopped once it's found more.
+	MaxSearchResults = 1000
+
+	// Name of the file added to searches with more than MaxSearchResults matches. It contains a
+	// space, so it can't be the name of a result.
+	SearchTruncatedFileName = "more results not shown.txt"
+)
+
+// SearchRoot is a device directory that is mounted at MountPath, relative to the root of the mount.
+type SearchRoot struct {
+	MountPath  string
+	DevicePath string
+}
+
+// Query parameters accepted by the search directory, and the find predicates they're passed to.
+var searchPredicates = map[string]struct {
+	Predicate string
+	Pattern   *regexp.Regexp
+}{
+	"name":  {"-name", nil},
+	"iname": {"-iname", nil},
+	"size":  {"-size", regexp.MustCompile(`^[+-]?[0-9]+[ckMG]?$`)},
+	"mtime": {"-mtime", regexp.MustCompile(`^[+-]?[0-9]+$`)},
+	"mmin":  {"-mmin", regexp.MustCompile(`^[+-]?[0-9]+$`)},
+	"type":  {"-type", regexp.MustCompile(`^[fdl]$`)},
+}
+
+/*
+searchControlDir is a ControlDir whose children are searches. Each child is named by a URL-encoded
+query, and contains a symlink to every path inside the mount that matches the query, found by
+running find on the device. E.g.
+
+	.adbfs/search/name=*.jpg&mtime=-7&path=DCIM
+
+The directory itself is empty, since searches are only run when they're accessed. Names that aren't
+valid queries don't exist, since file managers look up names like .DS_Store in every directory.
+Only the first MaxSearchResults matches are listed.
+*/
+type searchControlDir struct {
+	clientFactory DeviceClientFactory
+	roots         []SearchRoot
+	// May be nil.
+	excludes   *Excludes
+	cache      *controlCache
+	maxResults int
+}
+
+func newSearchControlDir(clientFactory DeviceClientFactory, roots []SearchRoot, excludes *Excludes) *searchControlDir {
+	return &searchControlDir{
+		clientFactory: clientFactory,
+		roots:         roots,
+		excludes:      excludes,
+		cache:         newControlCache(SearchCacheTtl),
+		maxResults:    MaxSearchResults,
+	}
+}
+
+func (d *searchControlDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *searchControlDir) ListChildren() ([]fuse.DirEntry, error) {
+	return nil, nil
+}
+
+func (d *searchControlDir) Child(query string) (ControlNode, error) {
+	args, err := d.findArgs(query)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	return &searchResultsDir{d, args}, nil
+}
+
+// findArgs converts a query into the arguments to pass to find. Invalid queries return EINVAL.
+func (d *searchControlDir) findArgs(query string) ([]string, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, syscall.EINVAL
+	}
+
+	var searchPaths []string
+	for _, mountPath := range values["path"] {
+		devicePath, ok := d.devicePath(mountPath)
+		if !ok {
+			return nil, syscall.EINVAL
+		}
+		searchPaths = append(searchPaths, devicePath)
+	}
+	if len(searchPaths) == 0 {
+		for _, root := range d.roots {
+			searchPaths = append(searchPaths, root.DevicePath)
+		}
+	}
+
+	args := make([]string, 0, len(searchPaths))
+	for _, searchPath := range searchPaths {
+		// The trailing slash makes find follow the path if it's a symlink, like /sdcard usually is.
+		args = append(args, strings.TrimSuffix(searchPath, "/")+"/")
+	}
+
+	// Sort the keys so equivalent queries get the same arguments.
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "path" {
+			continue
+		}
+		predicate, ok := searchPredicates[key]
+		if !ok {
+			return nil, syscall.EINVAL
+		}
+		for _, value := range values[key] {
+			if predicate.Pattern != nil && !predicate.Pattern.MatchString(value) {
+				return nil, syscall.EINVAL
+			}
+			args = append(args, predicate.Predicate, value)
+		}
+	}
+	return args, nil
+}
+
+// devicePath returns the path on the device of mountPath, a path relative to the root of the mount.
+func (d *searchControlDir) devicePath(mountPath string) (string, bool) {
+	mountPath = strings.Trim(path.Clean("/"+mountPath), "/")
+	for _, root := range d.roots {
+		if rel, ok := relativePath(root.MountPath, mountPath); ok {
+			return path.Join("/", root.DevicePath, rel), true
+		}
+	}
+	return "", false
+}
+
+// mountPath returns the path relative to the root of the mount of devicePath, a path on the device.
+func (d *searchControlDir) mountPath(devicePath string) (string, bool) {
+	for _, root := range d.roots {
+		if rel, ok := relativePath(strings.Trim(root.DevicePath, "/"), strings.Trim(devicePath, "/")); ok && rel != "" {
+			return path.Join(root.MountPath, rel), true
+		}
+	}
+	return "", false
+}
+
+// searchResults are the matches of a search.
+type searchResults struct {
+	// Paths of the matches relative to the root of the mount, keyed by the name of their symlink.
+	links map[string]string
+	// True if find found more than maxResults matches.
+	truncated bool
+}
+
+// results runs find with args and returns its matches. Excluded paths are left out, since find
+// doesn't go through the ExcludingDeviceClient.
+func (d *searchControlDir) results(args []string) (*searchResults, error) {
+	results, err := d.cache.GetOrLoad(strings.Join(args, "\x00"), func() (interface{}, error) {
+		client := d.clientFactory()
+		// Arguments contain patterns, so they must be quoted to keep the shell from expanding them.
+		// head stops find once it's found one more match than is listed.
+		script := fmt.Sprintf("%s | head -n %d", joinShellCommand("find", args), d.maxResults+1)
+		output, err := client.RunScheduledCommand(CommandOperation, nil, "sh", "-c", script)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := parseFindOutput(output)
+		results := &searchResults{
+			links: make(map[string]string),
+		}
+		if len(paths) > d.maxResults {
+			paths = paths[:d.maxResults]
+			results.truncated = true
+		}
+		for _, devicePath := range paths {
+			if d.excludes != nil && d.excludes.Match(client, devicePath) {
+				continue
+			}
+			if mountPath, ok := d.mountPath(devicePath); ok {
+				results.links[url.PathEscape(mountPath)] = mountPath
+			}
+		}
+		return results, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results.(*searchResults), nil
+}
+
+// searchResultsDir is a ControlDir that contains a searchResultLink for every match of a search.
+type searchResultsDir struct {
+	search *searchControlDir
+	args   []string
+}
+
+func (d *searchResultsDir) GetAttr() (*fuse.Attr, error) {
+	return newControlDirAttr(), nil
+}
+
+func (d *searchResultsDir) ListChildren() ([]fuse.DirEntry, error) {
+	results, err := d.search.results(d.args)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(results.links)+1)
+	for name := range results.links {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: fuse.S_IFLNK,
+		})
+	}
+	if results.truncated {
+		entries = append(entries, fuse.DirEntry{
+			Name: SearchTruncatedFileName,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *searchResultsDir) Child(name string) (ControlNode, error) {
+	results, err := d.search.results(d.args)
+	if err != nil {
+		return nil, err
+	}
+	if results.truncated && name == SearchTruncatedFileName {
+		return dataControlFile(fmt.Sprintf(
+			"Only the first %d matches are listed. Add parameters to narrow the search.\n", d.search.maxResults)), nil
+	}
+	mountPath, ok := results.links[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	// Relative, so the link works wherever the device is mounted.
+	return searchResultLink(path.Join("../../..", mountPath)), nil
+}
+
+// searchResultLink is a ControlSymlink that points to a search match.
+type searchResultLink string
+
+func (l searchResultLink) GetAttr() (*fuse.Attr, error) {
+	return newControlAttr(fuse.S_IFLNK|0777, len(l)), nil
+}
+
+func (l searchResultLink) Readlink() (string, error) {
+	return string(l), nil
+}
+
+// relativePath returns the path of target relative to base, if target is base or inside it.
+// Both paths must be cleaned and have no leading or trailing slashes.
+func relativePath(base, target string) (string, bool) {
+	switch {
+	case base == "":
+		return target, true
+	case target == base:
+		return "", true
+	case strings.HasPrefix(target, base+"/"):
+		return strings.TrimPrefix(target, base+"/"), true
+	}
+	return "", false
+}
+
+// parseFindOutput returns the paths printed by find, skipping error messages.
+func parseFindOutput(output string) (paths []string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "/") {
+			paths = append(paths, path.Clean(line))
+		}
+	}
+	return paths
+}
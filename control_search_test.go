@@ -0,0 +1,146 @@
+package adbfs
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+)
+
+func TestSearchControlDir_FindArgs(t *testing.T) {
+	dir := newSearchControlDir(nil, []SearchRoot{
+		{MountPath: "sdcard", DevicePath: "/sdcard"},
+		{MountPath: "tmp", DevicePath: "/data/local/tmp"},
+	}, nil)
+
+	args, err := dir.findArgs("type=f&name=*.jpg&size=%2B1M")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/sdcard/", "/data/local/tmp/", "-name", "*.jpg", "-size", "+1M", "-type", "f"}, args)
+
+	args, err = dir.findArgs("path=sdcard%2FDCIM&mtime=-7")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/sdcard/DCIM/", "-mtime", "-7"}, args)
+
+	for _, query := range []string{
+		"foo=bar",
+		"size=big",
+		"type=x",
+		"path=other",
+		"name=%zz",
+	} {
+		_, err = dir.findArgs(query)
+		assert.Equal(t, syscall.EINVAL, err, query)
+	}
+}
+
+func TestSearchControlDir_MountPath(t *testing.T) {
+	dir := newSearchControlDir(nil, []SearchRoot{{DevicePath: "/sdcard"}}, nil)
+
+	mountPath, ok := dir.mountPath("/sdcard/DCIM/a.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, "DCIM/a.jpg", mountPath)
+
+	_, ok = dir.mountPath("/sdcard")
+	assert.False(t, ok)
+	_, ok = dir.mountPath("/sdcardx/a.jpg")
+	assert.False(t, ok)
+}
+
+func TestParseFindOutput(t *testing.T) {
+	paths := parseFindOutput("/sdcard//DCIM/a.jpg\r\nfind: /sdcard/x: Permission denied\r\n/sdcard/b c.jpg\r\n")
+	assert.Equal(t, []string{"/sdcard/DCIM/a.jpg", "/sdcard/b c.jpg"}, paths)
+}
+
+func TestControlFileSystem_Search(t *testing.T) {
+	var runs int
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		SearchRoots: []SearchRoot{{DevicePath: "/sdcard"}},
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					runs++
+					assert.Equal(t, "sh", cmd)
+					assert.Equal(t, []string{"-c", `'find' '/sdcard/' '-name' '*.jpg' | head -n 1001`}, args)
+					return "/sdcard/DCIM/a.jpg\n/sdcard/b.jpg\n", nil
+				},
+			}
+		},
+	})
+
+	entries, status := fs.OpenDir(".adbfs/search/name=*.jpg", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "DCIM%2Fa.jpg", Mode: fuse.S_IFLNK},
+		{Name: "b.jpg", Mode: fuse.S_IFLNK},
+	}, entries)
+
+	attr, status := fs.GetAttr(".adbfs/search/name=*.jpg/DCIM%2Fa.jpg", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.True(t, attr.Mode&fuse.S_IFLNK == fuse.S_IFLNK)
+
+	target, status := fs.Readlink(".adbfs/search/name=*.jpg/DCIM%2Fa.jpg", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, "../../../DCIM/a.jpg", target)
+	assert.Equal(t, 1, runs)
+
+	_, status = fs.Readlink(".adbfs/search/name=*.jpg", nil)
+	assert.Equal(t, fuse.EINVAL, status)
+	for _, name := range []string{"foo=bar", ".DS_Store", "._name=*.jpg", "desktop.ini"} {
+		_, status = fs.GetAttr(".adbfs/search/"+name, nil)
+		assert.Equal(t, fuse.ENOENT, status, name)
+	}
+}
+
+func TestControlFileSystem_SearchTruncated(t *testing.T) {
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		SearchRoots: []SearchRoot{{DevicePath: "/sdcard"}},
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					assert.True(t, strings.HasSuffix(args[1], " | head -n 3"), args[1])
+					return "/sdcard/a.jpg\n/sdcard/b.jpg\n/sdcard/c.jpg\n", nil
+				},
+			}
+		},
+	})
+	fs.root.(*staticControlDir).children["search"].(*searchControlDir).maxResults = 2
+
+	entries, status := fs.OpenDir(".adbfs/search/name=*.jpg", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "a.jpg", Mode: fuse.S_IFLNK},
+		{Name: "b.jpg", Mode: fuse.S_IFLNK},
+		{Name: SearchTruncatedFileName, Mode: fuse.S_IFREG},
+	}, entries)
+
+	attr, status := fs.GetAttr(".adbfs/search/name=*.jpg/"+SearchTruncatedFileName, nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.True(t, attr.Mode&fuse.S_IFREG == fuse.S_IFREG)
+}
+
+func TestControlFileSystem_SearchExcludes(t *testing.T) {
+	excludes, err := NewExcludes([]string{"/sdcard"}, []string{"Android/data"})
+	assert.NoError(t, err)
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		SearchRoots: []SearchRoot{{DevicePath: "/sdcard"}},
+		Excludes:    excludes,
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				stat: statFiles(&adb.DirEntry{Name: "/sdcard", Mode: os.ModeDir}),
+				runCommand: func(cmd string, args []string) (string, error) {
+					return "/sdcard/a.jpg\n/sdcard/Android/data/b.jpg\n", nil
+				},
+			}
+		},
+	})
+
+	entries, status := fs.OpenDir(".adbfs/search/name=*.jpg", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{{Name: "a.jpg", Mode: fuse.S_IFLNK}}, entries)
+	_, status = fs.GetAttr(".adbfs/search/name=*.jpg/Android%2Fdata%2Fb.jpg", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
@@ -0,0 +1,342 @@
+package adbfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+// How long to cache the list of settings in a namespace.
+const SettingsCacheTtl = 2 * time.Second
+
+// Namespaces of the settings provider.
+var settingsNamespaces = []string{"system", "secure", "global"}
+
+func newSettingsControlDir(clientFactory DeviceClientFactory, readOnly bool) ControlDir {
+	children := make(map[string]ControlNode)
+	for _, namespace := range settingsNamespaces {
+		children[namespace] = &settingsNamespaceDir{
+			clientFactory: clientFactory,
+			readOnly:      readOnly,
+			namespace:     namespace,
+			cache:         newControlCache(SettingsCacheTtl),
+			created:       make(map[string]bool),
+		}
+	}
+	return newStaticControlDir(children)
+}
+
+// settingsNamespaceDir is a ControlDir that contains a settingControlFile for every setting in a
+// namespace. New settings can be created by creating files.
+type settingsNamespaceDir struct {
+	clientFactory DeviceClientFactory
+	readOnly      bool
+	namespace     string
+	cache         *controlCache
+
+	// Keys of settings that have been created but not written yet.
+	lock    sync.Mutex
+	created map[string]bool
+}
+
+// settings returns all the settings in the namespace, mapped to their values.
+func (d *settingsNamespaceDir) settings() (map[string]string, error) {
+	settings, err := d.cache.GetOrLoad("settings", func() (interface{}, error) {
+		output, err := d.clientFactory().RunCommand("settings", "list", d.namespace)
+		if err != nil {
+			return nil, err
+		}
+		return parseSettingsListOutput(output), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settings.(map[string]string), nil
+}
+
+func (d *settingsNamespaceDir) get(key string) (string, error) {
+	output, err := d.clientFactory().RunCommand("settings", "get", d.namespace, key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(output, "\r\n"), nil
+}
+
+func (d *settingsNamespaceDir) put(key, value string) error {
+	// The value may contain anything, so quote it for the shell.
+	command := joinShellCommand("settings", []string{"put", d.namespace, key, value})
+	output, err := d.clientFactory().RunCommand("sh", "-c", command)
+	d.cache.Clear()
+	if err == nil {
+		err = parseSettingsPutOutput(output)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	delete(d.created, key)
+	d.lock.Unlock()
+	return nil
+}
+
+func (d *settingsNamespaceDir) GetAttr() (*fuse.Attr, error) {
+	if d.readOnly {
+		return newControlDirAttr(), nil
+	}
+	return newControlAttr(fuse.S_IFDIR|0755, 0), nil
+}
+
+func (d *settingsNamespaceDir) ListChildren() ([]fuse.DirEntry, error) {
+	settings, err := d.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(settings))
+	for key := range settings {
+		entries = append(entries, fuse.DirEntry{
+			Name: key,
+			Mode: fuse.S_IFREG,
+		})
+	}
+	sort.Sort(dirEntriesByName(entries))
+	return entries, nil
+}
+
+func (d *settingsNamespaceDir) Child(name string) (ControlNode, error) {
+	settings, err := d.settings()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := settings[name]
+	if !ok {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if !d.created[name] {
+			return nil, syscall.ENOENT
+		}
+	}
+	return &settingControlFile{d, name, value}, nil
+}
+
+func (d *settingsNamespaceDir) Create(name string, flags FileOpenFlags, _ os.FileMode, _ *LogEntry) (nodefs.File, error) {
+	if d.readOnly || !isValidControlName(name) {
+		return nil, ErrNotPermitted
+	}
+
+	d.lock.Lock()
+	d.created[name] = true
+	d.lock.Unlock()
+	return newSettingFile(d, name, nil, flags), nil
+}
+
+// settingControlFile is a ControlFile containing the value of a setting. Writes are applied with
+// settings put when the file is closed.
+type settingControlFile struct {
+	dir   *settingsNamespaceDir
+	key   string
+	value string
+}
+
+func (f *settingControlFile) GetAttr() (*fuse.Attr, error) {
+	perms := uint32(0644)
+	if f.dir.readOnly {
+		perms = 0444
+	}
+	return newControlFileAttr(perms, len(f.value)+1), nil
+}
+
+func (f *settingControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	if f.dir.readOnly && flags.Contains(O_RDWR|O_WRONLY|O_CREATE|O_TRUNC|O_APPEND) {
+		return nil, ErrNotPermitted
+	}
+
+	var data []byte
+	if !flags.Contains(O_TRUNC) {
+		value, err := f.dir.get(f.key)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(value + "\n")
+	}
+	return newSettingFile(f.dir, f.key, data, flags), nil
+}
+
+func (f *settingControlFile) Truncate(size uint64) error {
+	if f.dir.readOnly {
+		return ErrNotPermitted
+	}
+
+	value, err := f.dir.get(f.key)
+	if err != nil {
+		return err
+	}
+	if size < uint64(len(value)) {
+		value = value[:size]
+	}
+	return f.dir.put(f.key, value)
+}
+
+// settingFile is a nodefs.File that holds the value of a setting while it's open.
+type settingFile struct {
+	nodefs.File
+	dir   *settingsNamespaceDir
+	key   string
+	flags FileOpenFlags
+
+	lock  sync.Mutex
+	data  []byte
+	dirty bool
+}
+
+// newSettingFile returns a settingFile for key containing data. If the file was created or
+// truncated, it is written even if nothing else is written to it.
+func newSettingFile(dir *settingsNamespaceDir, key string, data []byte, flags FileOpenFlags) nodefs.File {
+	// Direct IO, since the size reported by the dir's GetAttr may be stale.
+	return &nodefs.WithFlags{
+		File: &settingFile{
+			File:  nodefs.NewDefaultFile(),
+			dir:   dir,
+			key:   key,
+			flags: flags,
+			data:  data,
+			dirty: flags.Contains(O_CREATE | O_TRUNC),
+		},
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}
+}
+
+func (f *settingFile) String() string {
+	return fmt.Sprintf("settingFile(%s/%s, %s)", f.dir.namespace, f.key, f.flags)
+}
+
+func (f *settingFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *settingFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var n int
+	if off < int64(len(f.data)) {
+		n = copy(buf, f.data[off:])
+	}
+	return fuse.ReadResultData(buf[:n]), fuse.OK
+}
+
+func (f *settingFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	if !f.flags.CanWrite() {
+		return 0, fuse.EBADF
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if end := off + int64(len(data)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	n := copy(f.data[off:], data)
+	f.dirty = true
+	return uint32(n), fuse.OK
+}
+
+func (f *settingFile) Truncate(size uint64) fuse.Status {
+	if !f.flags.CanWrite() {
+		return fuse.EBADF
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if size <= uint64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, size-uint64(len(f.data)))...)
+	}
+	f.dirty = true
+	return fuse.OK
+}
+
+func (f *settingFile) GetAttr(out *fuse.Attr) fuse.Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	*out = *newControlFileAttr(0644, len(f.data))
+	return fuse.OK
+}
+
+// Flush writes the setting if the file was modified, so errors are reported by close.
+func (f *settingFile) Flush() fuse.Status {
+	logEntry := StartFileOperation("Flush", f.dir.namespace+"/"+f.key, "")
+	defer logEntry.FinishOperation()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.dirty {
+		return toFuseStatusLog(OK, logEntry)
+	}
+
+	// Editors and echo add a trailing newline that isn't part of the value.
+	value := strings.TrimRight(string(f.data), "\r\n")
+	if err := f.dir.put(f.key, value); err != nil {
+		return toFuseStatusLog(err, logEntry)
+	}
+	f.dirty = false
+	logEntry.Result("put %q", value)
+	return toFuseStatusLog(OK, logEntry)
+}
+
+/*
+parseSettingsListOutput parses the output of settings list and returns the settings mapped to their
+values.
+
+Sample output:
+
+	adb_enabled=1
+	airplane_mode_on=0
+*/
+func parseSettingsListOutput(output string) map[string]string {
+	settings := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			// Continuation of a multi-line value.
+			continue
+		}
+		if key := line[:i]; isValidControlName(key) {
+			settings[key] = line[i+1:]
+		}
+	}
+	return settings
+}
+
+// parseSettingsPutOutput returns an error if settings put printed anything, since it doesn't
+// print anything when it succeeds.
+func parseSettingsPutOutput(output string) error {
+	output = strings.TrimSpace(output)
+	switch {
+	case output == "":
+		return nil
+	case strings.Contains(output, "SecurityException"):
+		return ErrNoPermission
+	}
+	if i := strings.IndexByte(output, '\n'); i >= 0 {
+		output = strings.TrimSpace(output[:i])
+	}
+	return util.Errorf(util.AdbError, "settings put failed: %s", output)
+}
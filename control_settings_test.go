@@ -0,0 +1,98 @@
+package adbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+func TestParseSettingsListOutput(t *testing.T) {
+	settings := parseSettingsListOutput("adb_enabled=1\r\nurl=http://example.com/?a=b\r\nline\r\nempty=\r\n")
+	assert.Equal(t, map[string]string{
+		"adb_enabled": "1",
+		"url":         "http://example.com/?a=b",
+		"empty":       "",
+	}, settings)
+}
+
+func TestParseSettingsPutOutput(t *testing.T) {
+	assert.NoError(t, parseSettingsPutOutput("\r\n"))
+	assert.Equal(t, ErrNoPermission, parseSettingsPutOutput("Exception occurred\njava.lang.SecurityException: Permission denial\n"))
+	assert.True(t, util.HasErrCode(parseSettingsPutOutput("Invalid namespace\n"), util.AdbError))
+}
+
+func newTestSettingsFileSystem(readOnly bool, commands *[]string) *ControlFileSystem {
+	return NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		ReadOnly: readOnly,
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: func(cmd string, args []string) (string, error) {
+					command := cmd + " " + strings.Join(args, " ")
+					*commands = append(*commands, command)
+					switch command {
+					case "settings list global":
+						return "adb_enabled=1\nairplane_mode_on=0\n", nil
+					case "settings get global airplane_mode_on":
+						return "0\n", nil
+					}
+					return "", nil
+				},
+			}
+		},
+	})
+}
+
+func TestControlFileSystem_SettingsRead(t *testing.T) {
+	var commands []string
+	fs := newTestSettingsFileSystem(true, &commands)
+
+	entries, status := fs.OpenDir(".adbfs/settings/global", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "adb_enabled", Mode: fuse.S_IFREG},
+		{Name: "airplane_mode_on", Mode: fuse.S_IFREG},
+	}, entries)
+
+	file, status := fs.Open(".adbfs/settings/global/airplane_mode_on", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	buf := make([]byte, 64)
+	result, status := file.Read(buf, 0)
+	assert.Equal(t, fuse.OK, status)
+	data, _ := result.Bytes(buf)
+	assert.Equal(t, "0\n", string(data))
+
+	_, status = fs.Open(".adbfs/settings/global/airplane_mode_on", uint32(O_WRONLY), nil)
+	assert.Equal(t, fuse.EPERM, status)
+	_, status = fs.Create(".adbfs/settings/global/new_key", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.EPERM, status)
+	assert.Equal(t, fuse.EPERM, fs.Truncate(".adbfs/settings/global/airplane_mode_on", 0, nil))
+}
+
+func TestControlFileSystem_SettingsWrite(t *testing.T) {
+	var commands []string
+	fs := newTestSettingsFileSystem(false, &commands)
+
+	file, status := fs.Open(".adbfs/settings/global/airplane_mode_on", uint32(O_WRONLY|O_TRUNC), nil)
+	assert.Equal(t, fuse.OK, status)
+	_, status = file.Write([]byte("1\n"), 0)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, fuse.OK, file.Flush())
+	assert.Equal(t, fuse.OK, file.Flush())
+	assert.Equal(t, []string{
+		"settings list global",
+		"sh -c 'settings' 'put' 'global' 'airplane_mode_on' '1'",
+	}, commands)
+
+	commands = nil
+	file, status = fs.Create(".adbfs/settings/global/new_key", uint32(O_WRONLY), 0644, nil)
+	assert.Equal(t, fuse.OK, status)
+	_, status = fs.GetAttr(".adbfs/settings/global/new_key", nil)
+	assert.Equal(t, fuse.OK, status)
+	_, status = file.Write([]byte("it's $HOME"), 0)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, fuse.OK, file.Flush())
+	assert.Equal(t, `sh -c 'settings' 'put' 'global' 'new_key' 'it'\\''s \$HOME'`, commands[len(commands)-1])
+}
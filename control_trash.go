@@ -0,0 +1,105 @@
+package adbfs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+const EmptyTrashControlFileName = "empty-trash"
+
+func newEmptyTrashControlFile(clientFactory DeviceClientFactory, trashes []*Trash) ControlFile {
+	return &actionControlFile{
+		name: EmptyTrashControlFileName,
+		action: func() error {
+			client := clientFactory()
+			for _, trash := range trashes {
+				if err := trash.Empty(client); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// actionControlFile is a write-only ControlFile that runs an action when it's written to and closed.
+type actionControlFile struct {
+	name   string
+	action func() error
+}
+
+func (f *actionControlFile) GetAttr() (*fuse.Attr, error) {
+	return newControlFileAttr(0200, 0), nil
+}
+
+func (f *actionControlFile) Open(flags FileOpenFlags, _ *LogEntry) (nodefs.File, error) {
+	if !flags.CanWrite() {
+		return nil, ErrNotPermitted
+	}
+	return &actionFile{
+		File:      nodefs.NewDefaultFile(),
+		control:   f,
+		triggered: flags.Contains(O_TRUNC),
+	}, nil
+}
+
+// Truncate runs the action, so it can also be triggered by truncating the file.
+func (f *actionControlFile) Truncate(size uint64) error {
+	return f.action()
+}
+
+// actionFile is a nodefs.File that runs the action of an actionControlFile when flushed, if it
+// was written to.
+type actionFile struct {
+	nodefs.File
+	control *actionControlFile
+
+	lock      sync.Mutex
+	triggered bool
+}
+
+func (f *actionFile) String() string {
+	return fmt.Sprintf("actionFile(%s)", f.control.name)
+}
+
+func (f *actionFile) InnerFile() nodefs.File {
+	return f.File
+}
+
+func (f *actionFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.triggered = true
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *actionFile) Truncate(size uint64) fuse.Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.triggered = true
+	return fuse.OK
+}
+
+func (f *actionFile) GetAttr(out *fuse.Attr) fuse.Status {
+	attr, _ := f.control.GetAttr()
+	*out = *attr
+	return fuse.OK
+}
+
+// Flush runs the action, so errors are reported by close.
+func (f *actionFile) Flush() fuse.Status {
+	logEntry := StartFileOperation("Flush", f.control.name, "")
+	defer logEntry.FinishOperation()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.triggered {
+		return toFuseStatusLog(OK, logEntry)
+	}
+	f.triggered = false
+	return toFuseStatusLog(f.control.action(), logEntry)
+}
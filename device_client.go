@@ -17,6 +17,11 @@ type DeviceClient interface {
 	ListDirEntries(path string, log *LogEntry) ([]*adb.DirEntry, error)
 
 	RunCommand(cmd string, args ...string) (string, error)
+
+	// RunScheduledCommand runs a command like RunCommand, but tells a Scheduler which class of
+	// operation it is, and records time spent waiting for it on log, which may be nil.
+	// Clients that override RunCommand must override this too.
+	RunScheduledCommand(class OperationClass, log *LogEntry, cmd string, args ...string) (string, error)
 }
 
 // goadbDeviceClient is an implementation of DeviceClient that wraps
@@ -77,6 +82,10 @@ func (c goadbDeviceClient) ListDirEntries(path string, _ *LogEntry) ([]*adb.DirE
 	return entries.ReadAll()
 }
 
+func (c goadbDeviceClient) RunScheduledCommand(_ OperationClass, _ *LogEntry, cmd string, args ...string) (string, error) {
+	return c.RunCommand(cmd, args...)
+}
+
 func (c goadbDeviceClient) handleDeviceNotFound(err error) error {
 	if c.deviceDisconnectedHandler != nil {
 		c.deviceDisconnectedHandler()
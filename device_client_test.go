@@ -41,6 +41,22 @@ func (c *delegateDeviceClient) RunCommand(cmd string, args ...string) (string, e
 	return c.runCommand(cmd, args)
 }
 
+func (c *delegateDeviceClient) RunScheduledCommand(_ OperationClass, _ *LogEntry, cmd string, args ...string) (string, error) {
+	return c.runCommand(cmd, args)
+}
+
+// recordCommands returns a runCommand func that appends every command line to commands, then
+// returns the result of run, or no output if run is nil.
+func recordCommands(commands *[]string, run func(cmd string, args []string) (string, error)) func(string, []string) (string, error) {
+	return func(cmd string, args []string) (string, error) {
+		*commands = append(*commands, cmd+" "+strings.Join(args, " "))
+		if run == nil {
+			return "", nil
+		}
+		return run(cmd, args)
+	}
+}
+
 func statFiles(entries ...*adb.DirEntry) func(string) (*adb.DirEntry, error) {
 	return func(path string) (*adb.DirEntry, error) {
 		for _, entry := range entries {
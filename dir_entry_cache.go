@@ -1,6 +1,7 @@
 package adbfs
 
 import (
+	"strings"
 	"time"
 
 	cache "github.com/pmylund/go-cache"
@@ -16,20 +17,41 @@ type DirEntryLoader func(path string) (*CachedDirEntries, error)
 type DirEntryCache interface {
 	GetOrLoad(path string, loader DirEntryLoader) (entries *CachedDirEntries, err error, hit bool)
 	Get(path string) (entries *CachedDirEntries, found bool)
-	// Removes the entry for path from the cache without blocking on other cache operations.
-	RemoveEventually(path string)
+	// Removes the entry for path from the cache before returning.
+	Remove(path string)
+	// Removes the entries for path and every directory under it from the cache before returning.
+	RemoveTree(path string)
+
+	// Records that the file at path doesn't exist.
+	SetMissing(path string)
+	// Returns true if the file at path was recently found to not exist.
+	IsMissing(path string) bool
+	// Forgets that the file at path doesn't exist, e.g. because it was just created.
+	RemoveMissing(path string)
+
+	// Removes everything from the cache, e.g. because the device is gone.
+	Clear()
 }
 
 type realDirEntryCache struct {
 	cache    *cache.Cache
 	eventLog trace.EventLog
+
+	// Paths of files that don't exist, or nil if they aren't cached.
+	missing *cache.Cache
 }
 
-func NewDirEntryCache(ttl time.Duration) DirEntryCache {
-	return &realDirEntryCache{
+// NewDirEntryCache returns a DirEntryCache that keeps directory listings for ttl, and remembers
+// that files don't exist for negativeTtl. If negativeTtl is 0, missing files aren't remembered.
+func NewDirEntryCache(ttl, negativeTtl time.Duration) DirEntryCache {
+	c := &realDirEntryCache{
 		cache:    cache.New(ttl, CachePurgeInterval),
 		eventLog: trace.NewEventLog("DirEntryCache", ""),
 	}
+	if negativeTtl > 0 {
+		c.missing = cache.New(negativeTtl, CachePurgeInterval)
+	}
+	return c
 }
 
 func (c *realDirEntryCache) GetOrLoad(path string, loader DirEntryLoader) (*CachedDirEntries, error, bool) {
@@ -55,6 +77,55 @@ func (c *realDirEntryCache) Get(path string) (*CachedDirEntries, bool) {
 	return nil, false
 }
 
-func (c *realDirEntryCache) RemoveEventually(path string) {
-	go c.cache.Delete(path)
+func (c *realDirEntryCache) Remove(path string) {
+	c.eventLog.Printf("Remove(%s)", path)
+	c.cache.Delete(path)
+}
+
+func (c *realDirEntryCache) RemoveTree(path string) {
+	c.eventLog.Printf("RemoveTree(%s)", path)
+	c.cache.Delete(path)
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for key := range c.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			c.cache.Delete(key)
+		}
+	}
+	if c.missing != nil {
+		for key := range c.missing.Items() {
+			if strings.HasPrefix(key, prefix) {
+				c.missing.Delete(key)
+			}
+		}
+	}
+}
+
+func (c *realDirEntryCache) SetMissing(path string) {
+	if c.missing != nil {
+		c.missing.Set(path, struct{}{}, cache.DefaultExpiration)
+	}
+}
+
+func (c *realDirEntryCache) IsMissing(path string) bool {
+	if c.missing == nil {
+		return false
+	}
+	_, found := c.missing.Get(path)
+	c.eventLog.Printf("IsMissing(%s) = %t", path, found)
+	return found
+}
+
+func (c *realDirEntryCache) RemoveMissing(path string) {
+	if c.missing != nil {
+		c.missing.Delete(path)
+	}
+}
+
+func (c *realDirEntryCache) Clear() {
+	c.eventLog.Printf("Clear()")
+	c.cache.Flush()
+	if c.missing != nil {
+		c.missing.Flush()
+	}
 }
@@ -10,9 +10,11 @@ import (
 )
 
 type delegateDirEntryCache struct {
-	DoGetOrLoad        func(path string, loader DirEntryLoader) (entries *CachedDirEntries, err error, hit bool)
-	DoGet              func(path string) (entries *CachedDirEntries, found bool)
-	DoRemoveEventually func(path string)
+	DoGetOrLoad  func(path string, loader DirEntryLoader) (entries *CachedDirEntries, err error, hit bool)
+	DoGet        func(path string) (entries *CachedDirEntries, found bool)
+	DoRemove     func(path string)
+	DoRemoveTree func(path string)
+	Missing      map[string]bool
 }
 
 func (c *delegateDirEntryCache) GetOrLoad(path string, loader DirEntryLoader) (entries *CachedDirEntries, err error, hit bool) {
@@ -23,12 +25,35 @@ func (c *delegateDirEntryCache) Get(path string) (entries *CachedDirEntries, fou
 	return c.DoGet(path)
 }
 
-func (c *delegateDirEntryCache) RemoveEventually(path string) {
-	c.DoRemoveEventually(path)
+func (c *delegateDirEntryCache) Remove(path string) {
+	c.DoRemove(path)
+}
+
+func (c *delegateDirEntryCache) RemoveTree(path string) {
+	c.DoRemoveTree(path)
+}
+
+func (c *delegateDirEntryCache) SetMissing(path string) {
+	if c.Missing == nil {
+		c.Missing = make(map[string]bool)
+	}
+	c.Missing[path] = true
+}
+
+func (c *delegateDirEntryCache) IsMissing(path string) bool {
+	return c.Missing[path]
+}
+
+func (c *delegateDirEntryCache) RemoveMissing(path string) {
+	delete(c.Missing, path)
+}
+
+func (c *delegateDirEntryCache) Clear() {
+	c.Missing = nil
 }
 
 func TestDirEntryCacheLoadSuccess(t *testing.T) {
-	cache := NewDirEntryCache(5 * time.Second)
+	cache := NewDirEntryCache(5*time.Second, 0)
 	loader := func(path string) (*CachedDirEntries, error) {
 		return &CachedDirEntries{
 			InOrder: []*adb.DirEntry{&adb.DirEntry{
@@ -45,7 +70,7 @@ func TestDirEntryCacheLoadSuccess(t *testing.T) {
 }
 
 func TestDirEntryCacheLoadFail(t *testing.T) {
-	cache := NewDirEntryCache(5 * time.Second)
+	cache := NewDirEntryCache(5*time.Second, 0)
 	loader := func(path string) (*CachedDirEntries, error) {
 		return nil, errors.New("the fail")
 	}
@@ -58,7 +83,7 @@ func TestDirEntryCacheLoadFail(t *testing.T) {
 }
 
 func TestDirEntryCacheHit(t *testing.T) {
-	cache := NewDirEntryCache(5 * time.Second)
+	cache := NewDirEntryCache(5*time.Second, 0)
 	loadCount := 0
 	loader := func(path string) (entries *CachedDirEntries, err error) {
 		loadCount++
@@ -77,14 +102,14 @@ func TestDirEntryCacheHit(t *testing.T) {
 }
 
 func TestDirEntryCacheMiss(t *testing.T) {
-	cache := NewDirEntryCache(5 * time.Second)
+	cache := NewDirEntryCache(5*time.Second, 0)
 	_, found := cache.Get("foobar")
 	assert.False(t, found)
 }
 
 func TestDirEntryCacheExpiry(t *testing.T) {
 	ttl := 10 * time.Millisecond
-	cache := NewDirEntryCache(ttl)
+	cache := NewDirEntryCache(ttl, 0)
 	loadCount := 0
 	loader := func(path string) (entries *CachedDirEntries, err error) {
 		loadCount++
@@ -102,3 +127,52 @@ func TestDirEntryCacheExpiry(t *testing.T) {
 	cache.GetOrLoad("foobar", loader)
 	assert.Equal(t, 2, loadCount)
 }
+
+func TestDirEntryCacheMissing(t *testing.T) {
+	ttl := 10 * time.Millisecond
+	cache := NewDirEntryCache(5*time.Second, ttl)
+	assert.False(t, cache.IsMissing("/foo"))
+
+	cache.SetMissing("/foo")
+	assert.True(t, cache.IsMissing("/foo"))
+	assert.False(t, cache.IsMissing("/bar"))
+
+	cache.RemoveMissing("/foo")
+	assert.False(t, cache.IsMissing("/foo"))
+
+	cache.SetMissing("/foo")
+	time.Sleep(ttl)
+	assert.False(t, cache.IsMissing("/foo"))
+}
+
+func TestDirEntryCacheMissingDisabled(t *testing.T) {
+	cache := NewDirEntryCache(5*time.Second, 0)
+	cache.SetMissing("/foo")
+	assert.False(t, cache.IsMissing("/foo"))
+	cache.RemoveMissing("/foo")
+}
+
+func TestDirEntryCacheRemoveTree(t *testing.T) {
+	cache := NewDirEntryCache(5*time.Second, 5*time.Second)
+	loader := func(path string) (entries *CachedDirEntries, err error) {
+		return
+	}
+	for _, path := range []string{"/a", "/a/b", "/a/b/c", "/ab"} {
+		cache.GetOrLoad(path, loader)
+	}
+	cache.SetMissing("/a/b/missing")
+	cache.SetMissing("/ab/missing")
+
+	cache.RemoveTree("/a/b")
+
+	_, found := cache.Get("/a")
+	assert.True(t, found)
+	_, found = cache.Get("/a/b")
+	assert.False(t, found)
+	_, found = cache.Get("/a/b/c")
+	assert.False(t, found)
+	_, found = cache.Get("/ab")
+	assert.True(t, found)
+	assert.False(t, cache.IsMissing("/a/b/missing"))
+	assert.True(t, cache.IsMissing("/ab/missing"))
+}
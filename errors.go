@@ -19,6 +19,14 @@ var (
 	ErrNoPermission = os.ErrPermission
 	// The operation is not permitted due to reasons other than user permission.
 	ErrNotPermitted = errors.New("operation not permitted")
+	// The path can't be modified because of the write rules.
+	ErrReadOnlyPath = errors.New("path is read-only")
+	// A case-insensitive lookup matched more than one file.
+	ErrAmbiguousName = errors.New("name matches more than one file")
+	// run-as doesn't know about the requested package.
+	ErrPackageUnknown = errors.New("package unknown")
+	// run-as refuses to run as a package that isn't debuggable.
+	ErrPackageNotDebuggable = errors.New("package not debuggable")
 )
 
 // toFuseStatusLog converts an Errno to a Status and logs it.
@@ -54,6 +62,15 @@ func toErrno(err error) syscall.Errno {
 		return syscall.EACCES
 	case err == ErrNotPermitted:
 		return syscall.EPERM
+	case err == ErrReadOnlyPath:
+		return syscall.EROFS
+	case err == ErrAmbiguousName:
+		// ENOTUNIQ would be more specific, but it doesn't exist on OS X.
+		return syscall.EINVAL
+	case err == ErrPackageUnknown:
+		return syscall.ENOENT
+	case err == ErrPackageNotDebuggable:
+		return syscall.EPERM
 	case util.HasErrCode(err, util.FileNoExistError):
 		return syscall.ENOENT
 	}
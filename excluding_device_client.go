@@ -0,0 +1,161 @@
+package adbfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+/*
+Excludes is a set of glob patterns, as accepted by matchGlob, for paths that are hidden from the
+mount. Patterns are relative to each device root, so "Android/data" hides /sdcard/Android/data when
+/sdcard is mounted.
+
+Device roots are often symlinks (e.g. /sdcard ➜ /storage/emulated/0), but clients only see resolved
+paths, so roots are resolved the first time a path is matched.
+*/
+type Excludes struct {
+	Patterns []string
+	Roots    []string
+
+	resolveOnce   sync.Once
+	resolvedRoots []string
+}
+
+func NewExcludes(roots, patterns []string) (*Excludes, error) {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = "/" + strings.TrimPrefix(pattern, "/")
+		if err := isValidGlob(pattern); err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, pattern)
+	}
+	return &Excludes{
+		Patterns: normalized,
+		Roots:    roots,
+	}, nil
+}
+
+// Match returns true if name, a path on the device, is excluded.
+func (e *Excludes) Match(client DeviceClient, name string) bool {
+	if len(e.Patterns) == 0 {
+		return false
+	}
+
+	e.resolveOnce.Do(func() {
+		e.resolvedRoots = e.resolveRoots(client)
+	})
+
+	name = strings.Trim(path.Clean("/"+name), "/")
+	for _, root := range e.resolvedRoots {
+		rel, ok := relativePath(root, name)
+		if !ok || rel == "" {
+			// Never hide the root itself.
+			continue
+		}
+		for _, pattern := range e.Patterns {
+			if matchGlob(pattern, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveRoots returns every root, and its target if it's a symlink, cleaned for relativePath.
+func (e *Excludes) resolveRoots(client DeviceClient) []string {
+	logEntry := StartOperation("ResolveExcludeRoots", strings.Join(e.Roots, ","))
+	defer logEntry.FinishOperation()
+
+	var roots []string
+	for _, root := range e.Roots {
+		root = path.Clean("/" + root)
+		roots = append(roots, strings.Trim(root, "/"))
+		if root == "/" {
+			continue
+		}
+
+		target, _, err := readLinkRecursively(client, root, logEntry)
+		if err != nil {
+			cli.Log.Warnf("error resolving exclude root %s, only matching unresolved path: %s", root, err)
+			continue
+		}
+		if target = strings.Trim(path.Clean(target), "/"); target != strings.Trim(root, "/") {
+			roots = append(roots, target)
+		}
+	}
+	return roots
+}
+
+// ExcludingDeviceClient is a DeviceClient that hides every path matched by Excludes.
+// Excluded paths don't exist as far as anything above this client is concerned: they're removed
+// from directory listings, and stating or opening them fails with FileNoExistError. Listing an
+// excluded directory fails without touching the device.
+type ExcludingDeviceClient struct {
+	DeviceClient
+	Excludes *Excludes
+}
+
+func NewExcludingDeviceClientFactory(excludes *Excludes, factory DeviceClientFactory) DeviceClientFactory {
+	return func() DeviceClient {
+		return &ExcludingDeviceClient{
+			DeviceClient: factory(),
+			Excludes:     excludes,
+		}
+	}
+}
+
+func (c *ExcludingDeviceClient) Stat(name string, log *LogEntry) (*adb.DirEntry, error) {
+	if err := c.checkExcluded(name); err != nil {
+		return nil, err
+	}
+	return c.DeviceClient.Stat(name, log)
+}
+
+func (c *ExcludingDeviceClient) ListDirEntries(dir string, log *LogEntry) ([]*adb.DirEntry, error) {
+	if err := c.checkExcluded(dir); err != nil {
+		return nil, err
+	}
+
+	entries, err := c.DeviceClient.ListDirEntries(dir, log)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]*adb.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !c.Excludes.Match(c.DeviceClient, path.Join(dir, entry.Name)) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+func (c *ExcludingDeviceClient) OpenRead(name string, log *LogEntry) (io.ReadCloser, error) {
+	if err := c.checkExcluded(name); err != nil {
+		return nil, err
+	}
+	return c.DeviceClient.OpenRead(name, log)
+}
+
+func (c *ExcludingDeviceClient) OpenWrite(name string, perms os.FileMode, mtime time.Time, log *LogEntry) (io.WriteCloser, error) {
+	if err := c.checkExcluded(name); err != nil {
+		return nil, err
+	}
+	return c.DeviceClient.OpenWrite(name, perms, mtime, log)
+}
+
+func (c *ExcludingDeviceClient) checkExcluded(name string) error {
+	if c.Excludes.Match(c.DeviceClient, name) {
+		return util.Errorf(util.FileNoExistError, "%s is excluded", name)
+	}
+	return nil
+}
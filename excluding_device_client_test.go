@@ -0,0 +1,112 @@
+package adbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+func newTestExcludingDeviceClient(t *testing.T, roots []string, patterns ...string) (*ExcludingDeviceClient, *[]string) {
+	var listed []string
+	excludes, err := NewExcludes(roots, patterns)
+	assert.NoError(t, err)
+	return &ExcludingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			stat: statFiles(&adb.DirEntry{
+				Name: "/sdcard",
+				Mode: os.ModeSymlink,
+			}, &adb.DirEntry{
+				Name: "/storage/emulated/0",
+				Mode: os.ModeDir,
+			}, &adb.DirEntry{
+				Name: "/storage/emulated/0/DCIM",
+				Mode: os.ModeDir,
+			}),
+			runCommand: func(cmd string, args []string) (string, error) {
+				if cmd == "readlink" && args[0] == "/sdcard" {
+					return "/storage/emulated/0", nil
+				}
+				t.Fatal("invalid command:", cmd, args)
+				return "", nil
+			},
+			listDirEntries: func(path string) ([]*adb.DirEntry, error) {
+				listed = append(listed, path)
+				return []*adb.DirEntry{
+					{Name: "DCIM"},
+					{Name: ".thumbnails"},
+					{Name: "Android"},
+				}, nil
+			},
+		},
+		Excludes: excludes,
+	}, &listed
+}
+
+func TestExcludingDeviceClient_ListDirEntries(t *testing.T) {
+	client, listed := newTestExcludingDeviceClient(t, []string{"/sdcard"}, "**/.thumbnails", "Android/data")
+
+	entries, err := client.ListDirEntries("/storage/emulated/0", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*adb.DirEntry{{Name: "DCIM"}, {Name: "Android"}}, entries)
+
+	entries, err = client.ListDirEntries("/storage/emulated/0/DCIM", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*adb.DirEntry{{Name: "DCIM"}, {Name: "Android"}}, entries)
+
+	_, err = client.ListDirEntries("/storage/emulated/0/Android/data", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+	_, err = client.ListDirEntries("/storage/emulated/0/Android/data/com.example", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+
+	assert.Equal(t, []string{"/storage/emulated/0", "/storage/emulated/0/DCIM"}, *listed)
+}
+
+func TestExcludingDeviceClient_Stat(t *testing.T) {
+	client, _ := newTestExcludingDeviceClient(t, []string{"/sdcard"}, "**/.thumbnails")
+
+	_, err := client.Stat("/storage/emulated/0/DCIM", &LogEntry{})
+	assert.NoError(t, err)
+	_, err = client.Stat("/storage/emulated/0/DCIM/.thumbnails", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+	_, err = client.Stat("/sdcard/.thumbnails", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+
+	_, err = client.OpenRead("/storage/emulated/0/.thumbnails/1.jpg", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+}
+
+func TestExcludingDeviceClient_OutsideRoots(t *testing.T) {
+	client, _ := newTestExcludingDeviceClient(t, []string{"/storage/emulated/0"}, "DCIM")
+
+	// The root itself is never excluded.
+	_, err := client.Stat("/storage/emulated/0", &LogEntry{})
+	assert.NoError(t, err)
+	assert.False(t, client.Excludes.Match(client.DeviceClient, "/DCIM"))
+	assert.True(t, client.Excludes.Match(client.DeviceClient, "/storage/emulated/0/DCIM"))
+}
+
+func TestNewExcludes_Invalid(t *testing.T) {
+	_, err := NewExcludes([]string{""}, []string{"[a"})
+	assert.Error(t, err)
+}
+
+func TestAdbFileSystem_ModifyExcluded(t *testing.T) {
+	client, _ := newTestExcludingDeviceClient(t, []string{"/sdcard"}, "Android/data")
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "/mnt",
+		DeviceRoot:    "/sdcard",
+		ClientFactory: func() DeviceClient { return client },
+		Excludes:      client.Excludes,
+	})
+	assert.NoError(t, err)
+
+	// The test client fails if any of these run a command on the device.
+	assert.Equal(t, fuse.ENOENT, fs.Mkdir("Android/data/foo", 0755, newContext()))
+	assert.Equal(t, fuse.ENOENT, fs.Rmdir("Android/data", newContext()))
+	assert.Equal(t, fuse.ENOENT, fs.Unlink("Android/data/foo", newContext()))
+	assert.Equal(t, fuse.ENOENT, fs.Rename("DCIM", "Android/data/DCIM", newContext()))
+}
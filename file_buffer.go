@@ -25,6 +25,9 @@ type FileBufferOptions struct {
 	// Set from the existing file if it exists, or to the desired new permissions if new.
 	Perms os.FileMode
 
+	// If not nil, called after the buffer is saved to the device.
+	SavedHandler func(*FileBuffer)
+
 	// Function called when ref count hits 0.
 	// Note that, because concurrency, the ref count may be incremented again by the time
 	// this function is executed.
@@ -194,6 +197,14 @@ func (f *FileBuffer) Size() int64 {
 	return f.buffer.Len()
 }
 
+// Discard frees the buffer and any unsaved changes, e.g. because the device is gone.
+func (f *FileBuffer) Discard() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.buffer = GrowableByteSlice{}
+	f.dirty.Clear()
+}
+
 func (f *FileBuffer) IsDirty() bool {
 	return f.dirty.IsSet()
 }
@@ -255,5 +266,8 @@ func (f *FileBuffer) saveToDevice(logEntry *LogEntry) error {
 	// dirty.
 	f.dirty.Clear()
 
+	if f.SavedHandler != nil {
+		f.SavedHandler(f)
+	}
 	return nil
 }
@@ -0,0 +1,63 @@
+package adbfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// matchGlob returns true if name, an absolute slash-separated path, matches pattern or is inside a
+// directory that matches pattern.
+//
+// Patterns use the syntax of path.Match for each path component, and ** matches any number of
+// components, including none. E.g. /sdcard/**/*.apk matches every APK anywhere under /sdcard.
+func matchGlob(pattern, name string) bool {
+	return matchGlobComponents(splitPath(pattern), splitPath(name))
+}
+
+func matchGlobComponents(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try matching the rest of the pattern at every remaining position.
+			for i := 0; i <= len(name); i++ {
+				if matchGlobComponents(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	// Anything left in name is inside the matched directory.
+	return true
+}
+
+// isValidGlob returns an error if pattern is malformed.
+func isValidGlob(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern must be an absolute path: %s", pattern)
+	}
+	for _, component := range splitPath(pattern) {
+		if _, err := path.Match(component, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
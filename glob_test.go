@@ -0,0 +1,38 @@
+package adbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		Pattern string
+		Name    string
+		Match   bool
+	}{
+		{"/sdcard/Download", "/sdcard/Download", true},
+		{"/sdcard/Download", "/sdcard/Download/file.txt", true},
+		{"/sdcard/Download", "/sdcard/Download/dir/file.txt", true},
+		{"/sdcard/Download", "/sdcard/Downloads", false},
+		{"/sdcard/Download", "/sdcard", false},
+		{"/sdcard/Download/", "/sdcard/Download/file.txt", true},
+		{"/sdcard/*.txt", "/sdcard/file.txt", true},
+		{"/sdcard/*.txt", "/sdcard/dir/file.txt", false},
+		{"/sdcard/**/*.txt", "/sdcard/file.txt", true},
+		{"/sdcard/**/*.txt", "/sdcard/a/b/file.txt", true},
+		{"/sdcard/**/*.txt", "/sdcard/a/b/file.jpg", false},
+		{"/**/cache", "/data/local/cache/file", true},
+		{"/**", "/anything", true},
+		{"/", "/anything", true},
+	} {
+		assert.Equal(t, test.Match, matchGlob(test.Pattern, test.Name), "%v", test)
+	}
+}
+
+func TestIsValidGlob(t *testing.T) {
+	assert.NoError(t, isValidGlob("/sdcard/**/*.txt"))
+	assert.Error(t, isValidGlob("sdcard"))
+	assert.Error(t, isValidGlob("/sdcard/[a"))
+}
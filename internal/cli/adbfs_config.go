@@ -5,33 +5,47 @@ import "gopkg.in/alecthomas/kingpin.v2"
 type AdbfsConfig struct {
 	BaseConfig
 
-	DeviceSerial string
-	Mountpoint   string
+	DeviceSerial  string
+	Mountpoint    string
+	AllDevices    bool
+	DeviceAliases string
 }
 
 const (
-	DeviceSerialFlag = "device"
-	MountpointFlag   = "mountpoint"
+	DeviceSerialFlag  = "device"
+	MountpointFlag    = "mountpoint"
+	AllDevicesFlag    = "all-devices"
+	DeviceAliasesFlag = "device-aliases"
 )
 
 func RegisterAdbfsFlags(config *AdbfsConfig) {
 	registerBaseFlags(&config.BaseConfig)
 
 	kingpin.Flag(DeviceSerialFlag,
-		"Serial number of device to mount.").
+		"Serial number of device to mount. Required unless --"+AllDevicesFlag+" is specified.").
 		Short('s').
-		Required().
 		StringVar(&config.DeviceSerial)
 	kingpin.Flag(MountpointFlag,
 		"Directory to mount the device on.").
 		PlaceHolder("/mnt").
 		Required().
 		StringVar(&config.Mountpoint)
+	kingpin.Flag(AllDevicesFlag,
+		"Mount every connected device in its own subdirectory of the mountpoint. "+
+			"Subdirectories are added and removed as devices are connected and disconnected.").
+		BoolVar(&config.AllDevices)
+	kingpin.Flag(DeviceAliasesFlag,
+		"Names to use for device subdirectories instead of serial numbers, with --"+AllDevicesFlag+". "+
+			"Comma-separated list of serial=alias mappings.").
+		PlaceHolder("02b5c5a809117c73=nexus5").
+		StringVar(&config.DeviceAliases)
 }
 
 func (c *AdbfsConfig) AsArgs() []string {
 	return append(c.BaseConfig.AsArgs(),
 		formatFlag(DeviceSerialFlag, c.DeviceSerial),
 		formatFlag(MountpointFlag, c.Mountpoint),
+		formatFlag(AllDevicesFlag, c.AllDevices),
+		formatFlag(DeviceAliasesFlag, c.DeviceAliases),
 	)
 }
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"time"
 
@@ -11,34 +12,84 @@ import (
 )
 
 const (
-	DefaultPoolSize = 2
-	DefaultCacheTtl = 300 * time.Millisecond
-	DefaultLogLevel = logrus.InfoLevel
+	DefaultPoolSize       = 2
+	DefaultMaxMetadataOps = 4
+	DefaultMaxTransfers   = 2
+	DefaultCacheTtl       = 300 * time.Millisecond
+	DefaultNegativeTtl    = 300 * time.Millisecond
+	DefaultPropsCacheTtl  = 5 * time.Second
+	DefaultTrashExpiry    = 7 * 24 * time.Hour
+	DefaultDeviceRoot     = "/sdcard"
+	DefaultLogLevel       = logrus.InfoLevel
+	DefaultSymlinkPolicy  = "rewrite"
+
+	// go-fuse's defaults.
+	DefaultEntryTimeout    = time.Second
+	DefaultAttrTimeout     = time.Second
+	DefaultNegativeTimeout = 0
 )
 
 type BaseConfig struct {
 	// Command-line arguments. Each variable in this block should have a line in AsArgs().
 	AdbPort            int
 	ConnectionPoolSize int
+	MaxMetadataOps     int
+	MaxTransfers       int
 	LogLevel           string
 	Verbose            bool
 	CacheTtl           time.Duration
+	NegativeCacheTtl   time.Duration
 	ServeDebug         bool
 	DeviceRoot         string
+	DeviceRoots        string
 	ReadOnly           bool
 	PathToAdb          string
+	RunAsPackage       string
+	AsRoot             bool
+	ShowControlDir     bool
+	PropsCacheTtl      time.Duration
+	OnInstallHandlers  []string
+	UseTrash           bool
+	TrashExpiry        time.Duration
+	AllowWrites        []string
+	DenyWrites         []string
+	Excludes           []string
+	CaseInsensitive    bool
+	SymlinkPolicy      string
+	EntryTimeout       time.Duration
+	AttrTimeout        time.Duration
+	NegativeTimeout    time.Duration
 }
 
 const (
 	AdbPortFlag            = "port"
 	ConnectionPoolSizeFlag = "pool"
+	MaxMetadataOpsFlag     = "max-metadata-ops"
+	MaxTransfersFlag       = "max-transfers"
 	CacheTtlFlag           = "cachettl"
+	NegativeCacheTtlFlag   = "negative-cachettl"
 	LogLevelFlag           = "log"
 	VerboseFlag            = "verbose"
 	ServeDebugFlag         = "debug"
 	DeviceRootFlag         = "device-root"
+	DeviceRootsFlag        = "device-roots"
 	ReadOnlyFlag           = "readonly"
 	PathToAdb              = "adb"
+	RunAsPackageFlag       = "run-as"
+	AsRootFlag             = "as-root"
+	ShowControlDirFlag     = "show-control-dir"
+	PropsCacheTtlFlag      = "props-cachettl"
+	OnInstallHandlerFlag   = "on-install"
+	UseTrashFlag           = "trash"
+	TrashExpiryFlag        = "trash-expiry"
+	AllowWritesFlag        = "allow-writes"
+	DenyWritesFlag         = "deny-writes"
+	ExcludeFlag            = "exclude"
+	CaseInsensitiveFlag    = "case-insensitive"
+	SymlinkPolicyFlag      = "symlinks"
+	EntryTimeoutFlag       = "entry-timeout"
+	AttrTimeoutFlag        = "attr-timeout"
+	NegativeTimeoutFlag    = "negative-timeout"
 )
 
 func registerBaseFlags(config *BaseConfig) {
@@ -50,17 +101,35 @@ func registerBaseFlags(config *BaseConfig) {
 		"Size of the connection pool. Not used for open files.").
 		Default(strconv.Itoa(DefaultPoolSize)).
 		IntVar(&config.ConnectionPoolSize)
+	kingpin.Flag(MaxMetadataOpsFlag,
+		"Maximum number of concurrent metadata operations (stat, list, readlink, etc.). These always take priority over file transfers.").
+		Default(strconv.Itoa(DefaultMaxMetadataOps)).
+		IntVar(&config.MaxMetadataOps)
+	kingpin.Flag(MaxTransfersFlag,
+		"Maximum number of concurrent file transfers, and separately of long-running commands like screencap and find.").
+		Default(strconv.Itoa(DefaultMaxTransfers)).
+		IntVar(&config.MaxTransfers)
 	kingpin.Flag(CacheTtlFlag,
 		"Duration to keep cached file info.").
 		Default(DefaultCacheTtl.String()).
 		DurationVar(&config.CacheTtl)
+	kingpin.Flag(NegativeCacheTtlFlag,
+		"Duration to remember that files don't exist, for paths outside cached directories. "+
+			"Files created on the device by something else may not appear for this long. 0 disables it.").
+		Default(DefaultNegativeTtl.String()).
+		DurationVar(&config.NegativeCacheTtl)
 	kingpin.Flag(ServeDebugFlag,
 		"If set, will start an HTTP server to expose profiling and trace logs. Off by default.").
 		BoolVar(&config.ServeDebug)
 	kingpin.Flag(DeviceRootFlag,
 		"The device directory to mount.").
-		Default("/sdcard").
+		Default(DefaultDeviceRoot).
 		StringVar(&config.DeviceRoot)
+	kingpin.Flag(DeviceRootsFlag,
+		"Mount multiple device directories, each in its own subdirectory of the mountpoint, instead of --"+DeviceRootFlag+". "+
+			"Comma-separated list of name=path mappings.").
+		PlaceHolder("sdcard=/sdcard,tmp=/data/local/tmp").
+		StringVar(&config.DeviceRoots)
 	kingpin.Flag(ReadOnlyFlag,
 		"Mount as a readonly filesystem. True by default, since write support is still experimental. Use --no-readonly to enable writes.").
 		Short('r').
@@ -69,6 +138,78 @@ func registerBaseFlags(config *BaseConfig) {
 	kingpin.Flag(PathToAdb,
 		"Path to the adb executable. If unspecified, the PATH environment variable will be searched.").
 		StringVar(&config.PathToAdb)
+	kingpin.Flag(RunAsPackageFlag,
+		"Access files as the given debuggable app by running shell commands with run-as instead of using the sync service. "+
+			"If --"+DeviceRootFlag+" is not specified, the app's data directory is mounted.").
+		PlaceHolder("com.example.app").
+		StringVar(&config.RunAsPackage)
+	kingpin.Flag(AsRootFlag,
+		"Access files as root, using su unless adbd is already running as root. adbfs doesn't run adb root itself, "+
+			"since that restarts adbd. Falls back to the shell user if root is not available.").
+		BoolVar(&config.AsRoot)
+	kingpin.Flag(ShowControlDirFlag,
+		"List the virtual .adbfs directory, which exposes device information, in the root of the mount. "+
+			"It can always be accessed by path.").
+		BoolVar(&config.ShowControlDir)
+	kingpin.Flag(PropsCacheTtlFlag,
+		"Duration to keep cached system properties in .adbfs/props.").
+		Default(DefaultPropsCacheTtl.String()).
+		DurationVar(&config.PropsCacheTtl)
+	kingpin.Flag(OnInstallHandlerFlag,
+		`Command(s) to run after an APK copied into .adbfs/install is installed.
+May be repeated to specify multiple commands.
+The following variables will be defined in the command's environment:
+`+describeInstallHandlerVars()).
+		PlaceHolder(fmt.Sprintf(`"say $%s"`, InstallResultHandlerVar)).
+		StringsVar(&config.OnInstallHandlers)
+	kingpin.Flag(UseTrashFlag,
+		"Move deleted files to a .Trash-adbfs directory in the device root instead of deleting them. "+
+			"Files deleted from the trash are deleted permanently, and writing to .adbfs/empty-trash empties it.").
+		BoolVar(&config.UseTrash)
+	kingpin.Flag(TrashExpiryFlag,
+		"Duration to keep files in the trash before deleting them permanently. 0 keeps them forever.").
+		Default(DefaultTrashExpiry.String()).
+		DurationVar(&config.TrashExpiry)
+	kingpin.Flag(AllowWritesFlag,
+		"Only allow writes to device paths that match this glob, and everything inside them, when not --"+ReadOnlyFlag+". "+
+			"* matches within a path component, ** matches any number of components. "+
+			"Prefix with a serial and a colon to only apply to that device. May be repeated.").
+		PlaceHolder("/sdcard/Download").
+		StringsVar(&config.AllowWrites)
+	kingpin.Flag(DenyWritesFlag,
+		"Deny writes to device paths that match this glob, and everything inside them, even if allowed by --"+AllowWritesFlag+". "+
+			"Uses the same syntax as --"+AllowWritesFlag+". May be repeated.").
+		PlaceHolder("/sdcard/DCIM").
+		StringsVar(&config.DenyWrites)
+	kingpin.Flag(ExcludeFlag,
+		"Hide paths that match this glob, relative to the device root, and everything inside them. "+
+			"* matches within a path component, ** matches any number of components, "+
+			"e.g. **/.thumbnails or Android/data. May be repeated.").
+		PlaceHolder("**/.thumbnails").
+		StringsVar(&config.Excludes)
+	kingpin.Flag(CaseInsensitiveFlag,
+		"If a path doesn't exist, look for an existing file that matches it ignoring case, e.g. DCIM/camera opens DCIM/Camera. "+
+			"New files keep the case they're created with.").
+		BoolVar(&config.CaseInsensitive)
+	kingpin.Flag(SymlinkPolicyFlag,
+		"How to present symlinks that point outside the device root. "+
+			"rewrite: point them inside the mountpoint, resolving them if that leads back into the device root; "+
+			"follow: show them as the file or directory they point to; hide: hide them.").
+		Default(DefaultSymlinkPolicy).
+		EnumVar(&config.SymlinkPolicy, "rewrite", "follow", "hide")
+	kingpin.Flag(EntryTimeoutFlag,
+		"Duration the kernel caches the results of looking up names, on top of --"+CacheTtlFlag+". "+
+			"Longer is faster, but files changed on the device by something else take longer to appear.").
+		Default(DefaultEntryTimeout.String()).
+		DurationVar(&config.EntryTimeout)
+	kingpin.Flag(AttrTimeoutFlag,
+		"Duration the kernel caches file attributes like size and mtime, on top of --"+CacheTtlFlag+".").
+		Default(DefaultAttrTimeout.String()).
+		DurationVar(&config.AttrTimeout)
+	kingpin.Flag(NegativeTimeoutFlag,
+		"Duration the kernel remembers that names don't exist, on top of --"+NegativeCacheTtlFlag+". 0 disables it.").
+		Default(time.Duration(DefaultNegativeTimeout).String()).
+		DurationVar(&config.NegativeTimeout)
 
 	logLevels := []string{
 		logrus.PanicLevel.String(),
@@ -89,17 +230,45 @@ func registerBaseFlags(config *BaseConfig) {
 // AsArgs returns a string array suitable to be passed to exec.Command that copies
 // the arguments defined in this package.
 func (c *BaseConfig) AsArgs() []string {
-	return []string{
+	args := []string{
 		formatFlag(AdbPortFlag, c.AdbPort),
 		formatFlag(ConnectionPoolSizeFlag, c.ConnectionPoolSize),
+		formatFlag(MaxMetadataOpsFlag, c.MaxMetadataOps),
+		formatFlag(MaxTransfersFlag, c.MaxTransfers),
 		formatFlag(LogLevelFlag, c.LogLevel),
 		formatFlag(CacheTtlFlag, c.CacheTtl),
+		formatFlag(NegativeCacheTtlFlag, c.NegativeCacheTtl),
 		formatFlag(ServeDebugFlag, c.ServeDebug),
 		formatFlag(VerboseFlag, c.Verbose),
 		formatFlag(DeviceRootFlag, c.DeviceRoot),
+		formatFlag(DeviceRootsFlag, c.DeviceRoots),
 		formatFlag(ReadOnlyFlag, c.ReadOnly),
 		formatFlag(PathToAdb, c.PathToAdb),
+		formatFlag(RunAsPackageFlag, c.RunAsPackage),
+		formatFlag(AsRootFlag, c.AsRoot),
+		formatFlag(ShowControlDirFlag, c.ShowControlDir),
+		formatFlag(PropsCacheTtlFlag, c.PropsCacheTtl),
+		formatFlag(UseTrashFlag, c.UseTrash),
+		formatFlag(TrashExpiryFlag, c.TrashExpiry),
+		formatFlag(CaseInsensitiveFlag, c.CaseInsensitive),
+		formatFlag(SymlinkPolicyFlag, c.SymlinkPolicy),
+		formatFlag(EntryTimeoutFlag, c.EntryTimeout),
+		formatFlag(AttrTimeoutFlag, c.AttrTimeout),
+		formatFlag(NegativeTimeoutFlag, c.NegativeTimeout),
+	}
+	for _, handler := range c.OnInstallHandlers {
+		args = append(args, formatFlag(OnInstallHandlerFlag, handler))
+	}
+	for _, rule := range c.AllowWrites {
+		args = append(args, formatFlag(AllowWritesFlag, rule))
+	}
+	for _, rule := range c.DenyWrites {
+		args = append(args, formatFlag(DenyWritesFlag, rule))
 	}
+	for _, pattern := range c.Excludes {
+		args = append(args, formatFlag(ExcludeFlag, pattern))
+	}
+	return args
 }
 
 // ServerConfig returns a adb.ServerConfig from CLI arguments.
@@ -110,6 +279,18 @@ func (c *BaseConfig) ServerConfig() adb.ServerConfig {
 	}
 }
 
+// ServerAddress returns the host:port of the adb server described by ServerConfig, for talking to
+// it directly.
+func (c *BaseConfig) ServerAddress() string {
+	config := c.ServerConfig()
+	host := config.Host
+	if host == "" {
+		// goadb's default.
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(config.Port))
+}
+
 func (c *BaseConfig) createLogger() *logrus.Logger {
 	log := logrus.StandardLogger()
 
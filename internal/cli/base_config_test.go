@@ -11,28 +11,74 @@ func TestAdbfsConfigAsArgs(t *testing.T) {
 	config := BaseConfig{
 		AdbPort:            10,
 		ConnectionPoolSize: 20,
+		MaxMetadataOps:     3,
+		MaxTransfers:       1,
 		LogLevel:           "warn",
 		CacheTtl:           30 * time.Second,
+		NegativeCacheTtl:   time.Second,
 		ServeDebug:         true,
 		DeviceRoot:         "/abc",
+		DeviceRoots:        "a=/a,b=/b",
 		ReadOnly:           true,
+		RunAsPackage:       "com.example",
+		AsRoot:             true,
+		ShowControlDir:     true,
+		PropsCacheTtl:      10 * time.Second,
+		OnInstallHandlers:  []string{"say installed", "echo $ADBFS_APK"},
+		UseTrash:           true,
+		CaseInsensitive:    true,
+		SymlinkPolicy:      "follow",
+		EntryTimeout:       2 * time.Second,
+		AttrTimeout:        3 * time.Second,
+		NegativeTimeout:    time.Second,
+		TrashExpiry:        time.Hour,
+		AllowWrites:        []string{"/sdcard/Download", "abc:/data/local/tmp"},
+		DenyWrites:         []string{"/sdcard/Download/keep"},
+		Excludes:           []string{"**/.thumbnails", "Android/data"},
 	}
 
 	expectedArgs := []string{
 		"--port=10",
 		"--pool=20",
+		"--max-metadata-ops=3",
+		"--max-transfers=1",
 		"--log=warn",
 		"--cachettl=30s",
+		"--negative-cachettl=1s",
 		"--debug",
 		"--no-verbose",
 		"--device-root=/abc",
+		"--device-roots=a=/a,b=/b",
 		"--readonly",
 		"--adb=",
+		"--run-as=com.example",
+		"--as-root",
+		"--show-control-dir",
+		"--props-cachettl=10s",
+		"--trash",
+		"--trash-expiry=1h0m0s",
+		"--case-insensitive",
+		"--symlinks=follow",
+		"--entry-timeout=2s",
+		"--attr-timeout=3s",
+		"--negative-timeout=1s",
+		"--on-install=say installed",
+		"--on-install=echo $ADBFS_APK",
+		"--allow-writes=/sdcard/Download",
+		"--allow-writes=abc:/data/local/tmp",
+		"--deny-writes=/sdcard/Download/keep",
+		"--exclude=**/.thumbnails",
+		"--exclude=Android/data",
 	}
 
 	assert.Equal(t, expectedArgs, config.AsArgs())
 }
 
+func TestServerAddress(t *testing.T) {
+	config := BaseConfig{AdbPort: 5038}
+	assert.Equal(t, "localhost:5038", config.ServerAddress())
+}
+
 func TestFormatBoolFlag(t *testing.T) {
 	assert.Equal(t, "--debug", formatFlag("debug", true))
 	assert.Equal(t, "--no-debug", formatFlag("debug", false))
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -14,6 +15,17 @@ import (
 
 var Log *logrus.Logger = logrus.StandardLogger()
 
+type debugPage struct {
+	Text string
+	Path string
+}
+
+// Pages added to the debug server's table of contents by RegisterDebugPage.
+var (
+	debugPagesLock sync.Mutex
+	debugPages     []debugPage
+)
+
 func init() {
 	kingpin.HelpFlag.Short('h')
 }
@@ -66,10 +78,7 @@ func initializeDebugServer(baseConfig *BaseConfig) {
 	if err != nil {
 		panic(err)
 	}
-	toc := []struct {
-		Text string
-		Path string
-	}{
+	toc := []debugPage{
 		{"Profiling", "/debug/pprof"},
 		{"Download a 30-second CPU profile", "/debug/pprof/profile"},
 		{"Download a trace file (add ?seconds=x to specify sample length)", "/debug/pprof/trace"},
@@ -77,7 +86,10 @@ func initializeDebugServer(baseConfig *BaseConfig) {
 		{"Event log", "/debug/events"},
 	}
 	http.HandleFunc("/debug", func(w http.ResponseWriter, req *http.Request) {
-		template.Execute(w, toc)
+		debugPagesLock.Lock()
+		pages := append(append([]debugPage(nil), toc...), debugPages...)
+		debugPagesLock.Unlock()
+		template.Execute(w, pages)
 	})
 
 	go func() {
@@ -91,6 +103,17 @@ func initializeDebugServer(baseConfig *BaseConfig) {
 	Log.Printf("debug server listening on http://%s/debug", listener.Addr())
 }
 
+// RegisterDebugPage serves handler at path on the debug server, and links to it from /debug.
+// May be called before or after the debug server is started, or if it's not started at all.
+// Panics if path is registered twice, so pages for things that come and go (e.g. devices) should
+// share a single page.
+func RegisterDebugPage(text, path string, handler http.Handler) {
+	debugPagesLock.Lock()
+	defer debugPagesLock.Unlock()
+	debugPages = append(debugPages, debugPage{text, path})
+	http.Handle(path, handler)
+}
+
 func formatFlag(name string, value interface{}) string {
 	switch value := value.(type) {
 	case bool:
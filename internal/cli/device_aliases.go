@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseDeviceAliases parses a comma-separated list of serial=alias mappings, e.g.
+// "02b5c5a809117c73=nexus5,emulator-5554=emu", and returns a map of serials to aliases.
+// An alias can't be the directory name of another device's serial, since the two would be mounted
+// in the same place.
+func ParseDeviceAliases(spec string) (map[string]string, error) {
+	mappings, err := parseMappings(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device aliases: %s", err)
+	}
+
+	aliases := make(map[string]string)
+	serials := make(map[string]string)
+	for _, m := range mappings {
+		if !isValidDirName(m.Value) {
+			return nil, fmt.Errorf("invalid alias for device %s: '%s'", m.Key, m.Value)
+		}
+		if serial, exists := serials[m.Value]; exists {
+			return nil, fmt.Errorf("alias %s used for both %s and %s", m.Value, serial, m.Key)
+		}
+		serials[m.Value] = m.Key
+		aliases[m.Key] = m.Value
+	}
+	for _, m := range mappings {
+		if serial, exists := serials[DeviceDirName(m.Key, nil)]; exists && serial != m.Key {
+			return nil, fmt.Errorf("alias for device %s collides with device %s", serial, m.Key)
+		}
+	}
+	return aliases, nil
+}
+
+// DeviceDirName returns the name of the directory for the device with serial: its alias, if it has
+// one, otherwise its serial with slashes replaced.
+func DeviceDirName(serial string, aliases map[string]string) string {
+	if alias, ok := aliases[serial]; ok {
+		return alias
+	}
+	return strings.Replace(serial, "/", "_", -1)
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceAliases(t *testing.T) {
+	aliases, err := ParseDeviceAliases("02b5c5a809117c73=nexus5, emulator-5554=emu")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"02b5c5a809117c73": "nexus5",
+		"emulator-5554":    "emu",
+	}, aliases)
+
+	aliases, err = ParseDeviceAliases("")
+	assert.NoError(t, err)
+	assert.Empty(t, aliases)
+}
+
+func TestDeviceDirName(t *testing.T) {
+	aliases := map[string]string{"emulator-5554": "emu"}
+	assert.Equal(t, "emu", DeviceDirName("emulator-5554", aliases))
+	assert.Equal(t, "host_abc", DeviceDirName("host/abc", aliases))
+}
+
+func TestParseDeviceAliasesInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"abc",
+		"abc=",
+		"abc=a/b",
+		"abc=x,abc=y",
+		"abc=x,def=x",
+		"abc=def,def=x",
+		"a/b=x,c=a_b",
+	} {
+		_, err := ParseDeviceAliases(spec)
+		assert.Error(t, err, spec)
+	}
+}
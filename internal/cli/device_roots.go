@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceRoot is a device directory mounted under Name in the root of the filesystem.
+type DeviceRoot struct {
+	Name string
+	Path string
+}
+
+// ParseDeviceRoots parses a comma-separated list of name=path mappings,
+// e.g. "sdcard=/sdcard,tmp=/data/local/tmp". Roots are returned in the order they were specified.
+func ParseDeviceRoots(spec string) ([]DeviceRoot, error) {
+	mappings, err := parseMappings(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device roots: %s", err)
+	}
+
+	var roots []DeviceRoot
+	for _, m := range mappings {
+		if !isValidDirName(m.Key) {
+			return nil, fmt.Errorf("invalid device root name: '%s'", m.Key)
+		}
+		if !strings.HasPrefix(m.Value, "/") {
+			return nil, fmt.Errorf("device root path must be absolute: %s=%s", m.Key, m.Value)
+		}
+		roots = append(roots, DeviceRoot{Name: m.Key, Path: m.Value})
+	}
+
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no device roots specified: '%s'", spec)
+	}
+	return roots, nil
+}
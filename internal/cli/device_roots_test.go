@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceRoots(t *testing.T) {
+	roots, err := ParseDeviceRoots("sdcard=/sdcard, tmp=/data/local/tmp,dcim=/sdcard/DCIM,")
+	assert.NoError(t, err)
+	assert.Equal(t, []DeviceRoot{
+		{"sdcard", "/sdcard"},
+		{"tmp", "/data/local/tmp"},
+		{"dcim", "/sdcard/DCIM"},
+	}, roots)
+}
+
+func TestParseDeviceRootsInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		",",
+		"sdcard",
+		"=/sdcard",
+		"a/b=/sdcard",
+		"..=/sdcard",
+		"sdcard=sdcard",
+		"a=/sdcard,a=/data",
+	} {
+		_, err := ParseDeviceRoots(spec)
+		assert.Error(t, err, spec)
+	}
+}
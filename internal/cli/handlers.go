@@ -12,6 +12,9 @@ const (
 	PathHandlerVar   = "ADBFS_PATH"
 	SerialHandlerVar = "ADBFS_SERIAL"
 	ModelHandlerVar  = "ADBFS_MODEL"
+
+	ApkHandlerVar           = "ADBFS_APK"
+	InstallResultHandlerVar = "ADBFS_INSTALL_RESULT"
 )
 
 func describeHandlerVars() string {
@@ -22,6 +25,14 @@ func describeHandlerVars() string {
 	return buffer.String()
 }
 
+func describeInstallHandlerVars() string {
+	var buffer bytes.Buffer
+	fmt.Fprintln(&buffer, SerialHandlerVar, "	- serial number of the device.")
+	fmt.Fprintln(&buffer, ApkHandlerVar, "	- name of the APK that was installed.")
+	fmt.Fprintln(&buffer, InstallResultHandlerVar, "	- output of pm install, e.g. Success.")
+	return buffer.String()
+}
+
 // FireHandlers executes each handler in handlers with all occurrences of $key or ${key}
 // replaced with values[key]. The map keys should be the *HandlerVar constants.
 func FireHandlers(handlers []string, values map[string]string) {
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+type mapping struct {
+	Key   string
+	Value string
+}
+
+// parseMappings parses a comma-separated list of key=value pairs, e.g. "a=1,b=2".
+// Whitespace around keys and values, and empty items, are ignored. Keys must be unique.
+func parseMappings(spec string) ([]mapping, error) {
+	var mappings []mapping
+	keys := make(map[string]bool)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mapping, expected key=value: %s", item)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key == "" {
+			return nil, fmt.Errorf("invalid mapping, missing key: %s", item)
+		}
+		if keys[key] {
+			return nil, fmt.Errorf("duplicate key: %s", key)
+		}
+		keys[key] = true
+
+		mappings = append(mappings, mapping{key, value})
+	}
+	return mappings, nil
+}
+
+// isValidDirName returns true if name can be used as a single path component in the mountpoint.
+func isValidDirName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
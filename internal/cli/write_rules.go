@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WriteRulesForDevice returns the patterns from rules that apply to the device with serial.
+//
+// Each rule is either a pattern, e.g. "/sdcard/Download", which applies to every device, or a
+// pattern prefixed with a serial and a colon, e.g. "02b5c5a809117c73:/sdcard/Download", which only
+// applies to that device. Patterns must be absolute paths.
+func WriteRulesForDevice(rules []string, serial string) ([]string, error) {
+	var patterns []string
+	for _, rule := range rules {
+		ruleSerial, pattern, err := parseWriteRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if ruleSerial == "" || ruleSerial == serial {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+func parseWriteRule(rule string) (serial, pattern string, err error) {
+	if strings.HasPrefix(rule, "/") {
+		return "", rule, nil
+	}
+
+	// Serials of network devices contain colons (e.g. 192.168.1.2:5555), but patterns start with
+	// a slash.
+	i := strings.Index(rule, ":/")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid write rule, expected /path or serial:/path: %s", rule)
+	}
+	return rule[:i], rule[i+1:], nil
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRulesForDevice(t *testing.T) {
+	rules := []string{
+		"/sdcard/Download",
+		"abc:/data/local/tmp",
+		"192.168.1.2:5555:/sdcard/DCIM",
+	}
+
+	patterns, err := WriteRulesForDevice(rules, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/sdcard/Download", "/data/local/tmp"}, patterns)
+
+	patterns, err = WriteRulesForDevice(rules, "192.168.1.2:5555")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/sdcard/Download", "/sdcard/DCIM"}, patterns)
+
+	patterns, err = WriteRulesForDevice(nil, "abc")
+	assert.NoError(t, err)
+	assert.Empty(t, patterns)
+}
+
+func TestWriteRulesForDeviceInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"sdcard",
+		":/sdcard",
+		"abc:sdcard",
+	} {
+		_, err := WriteRulesForDevice([]string{rule}, "abc")
+		assert.Error(t, err, rule)
+	}
+}
@@ -47,6 +47,9 @@ type LogEntry struct {
 
 	cacheUsed bool
 	cacheHit  bool
+
+	queueWaits int
+	queueWait  time.Duration
 }
 
 var traceEntryFormatter = new(logrus.JSONFormatter)
@@ -117,6 +120,13 @@ func (r *LogEntry) CacheUsed(hit bool) {
 	r.cacheHit = r.cacheHit || hit
 }
 
+// QueueWaited records that the operation had to wait d for a Scheduler before talking to the device.
+// May be called multiple times, the waits are added up.
+func (r *LogEntry) QueueWaited(d time.Duration) {
+	r.queueWaits++
+	r.queueWait += d
+}
+
 // FinishOperation should be deferred. It will log the duration of the operation, as well
 // as any results and/or errors.
 func (r *LogEntry) FinishOperation() {
@@ -146,6 +156,12 @@ func (r *LogEntry) finishOperation(suppress bool) {
 	if r.cacheUsed {
 		entry = entry.WithField("cache_hit", r.cacheHit)
 	}
+	if r.queueWaits > 0 {
+		entry = entry.WithFields(logrus.Fields{
+			"queue_waits":   r.queueWaits,
+			"queue_wait_ms": r.queueWait.Nanoseconds() / time.Millisecond.Nanoseconds(),
+		})
+	}
 
 	if !suppress {
 		entry.Debug(r.name)
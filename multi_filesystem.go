@@ -0,0 +1,414 @@
+package adbfs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// Permissions reported for the synthetic root directory of a MultiFileSystem.
+const multiRootPerms = 0555
+
+/*
+MultiFileSystem is an implementation of fuse.pathfs.FileSystem whose root is a synthetic,
+read-only directory containing a named entry for each child filesystem. All operations on paths
+below a child's entry are forwarded to that child with the entry name stripped.
+
+Children may be added and removed while mounted, and the kernel is notified so the root listing
+changes immediately. Children that notify the kernel of changes are given a Notifier for the paths
+below their entry, including children added after mounting.
+*/
+type MultiFileSystem struct {
+	lock     sync.RWMutex
+	children map[string]pathfs.FileSystem
+	// Names of children, in the order they were added, for listing.
+	names []string
+	// Nil until mounted.
+	notifier Notifier
+}
+
+var (
+	_ pathfs.FileSystem   = &MultiFileSystem{}
+	_ notifyingFileSystem = &MultiFileSystem{}
+)
+
+func NewMultiFileSystem() *MultiFileSystem {
+	return &MultiFileSystem{
+		children: make(map[string]pathfs.FileSystem),
+	}
+}
+
+// AddChild makes fs available under name. If a child already exists with that name, it is replaced.
+func (fs *MultiFileSystem) AddChild(name string, child pathfs.FileSystem) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, exists := fs.children[name]; !exists {
+		fs.names = append(fs.names, name)
+	}
+	fs.children[name] = child
+
+	if child, ok := child.(notifyingFileSystem); ok && fs.notifier != nil {
+		child.setNotifier(prefixNotifier{fs.notifier, name})
+	}
+	fs.notifyChildChanged(name)
+}
+
+// RemoveChild removes the child with name, and returns it or nil if there was no such child.
+func (fs *MultiFileSystem) RemoveChild(name string) pathfs.FileSystem {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	child, exists := fs.children[name]
+	if !exists {
+		return nil
+	}
+	delete(fs.children, name)
+	for i, n := range fs.names {
+		if n == name {
+			fs.names = append(fs.names[:i], fs.names[i+1:]...)
+			break
+		}
+	}
+	fs.notifyChildChanged(name)
+	return child
+}
+
+// notifyChildChanged makes the kernel look up the entry for name again, and reload the root's
+// listing. Must be called with the lock held.
+func (fs *MultiFileSystem) notifyChildChanged(name string) {
+	if fs.notifier != nil {
+		// The kernel may not process notifications until the current operation returns.
+		go notifyEntryChanged(fs.notifier, name)
+	}
+}
+
+// ChildNames returns the names of all children, in the order they were added.
+func (fs *MultiFileSystem) ChildNames() []string {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	return append([]string(nil), fs.names...)
+}
+
+// route returns the child responsible for name and the path of name relative to that child.
+// isRoot is true if name refers to the root directory itself, in which case child is nil.
+func (fs *MultiFileSystem) route(name string) (child pathfs.FileSystem, childPath string, isRoot bool) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return nil, "", true
+	}
+
+	childName := name
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		childName, childPath = name[:i], name[i+1:]
+	}
+
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	return fs.children[childName], childPath, false
+}
+
+// routeModification routes an operation that modifies name.
+// Modifying the root directory, or an entry in it, is not permitted.
+func (fs *MultiFileSystem) routeModification(name string) (pathfs.FileSystem, string, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot || childPath == "" {
+		return nil, "", fuse.Status(syscall.EPERM)
+	}
+	if child == nil {
+		return nil, "", fuse.ENOENT
+	}
+	return child, childPath, fuse.OK
+}
+
+// routePair routes an operation that involves two paths, which must be on the same child.
+func (fs *MultiFileSystem) routePair(oldName, newName string) (child pathfs.FileSystem, oldPath, newPath string, status fuse.Status) {
+	oldChild, oldPath, status := fs.routeModification(oldName)
+	if !status.Ok() {
+		return nil, "", "", status
+	}
+	newChild, newPath, status := fs.routeModification(newName)
+	if !status.Ok() {
+		return nil, "", "", status
+	}
+	if oldChild != newChild {
+		return nil, "", "", fuse.Status(syscall.EXDEV)
+	}
+	return oldChild, oldPath, newPath, fuse.OK
+}
+
+func (fs *MultiFileSystem) String() string {
+	return fmt.Sprintf("MultiFileSystem%v", fs.ChildNames())
+}
+
+func (fs *MultiFileSystem) SetDebug(debug bool) {
+	fs.forEachChild(func(_ string, child pathfs.FileSystem) {
+		child.SetDebug(debug)
+	})
+}
+
+func (fs *MultiFileSystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		logEntry := StartOperation("GetAttr", name)
+		defer logEntry.SuppressFinishOperation()
+
+		attr := &fuse.Attr{
+			Mode:  fuse.S_IFDIR | multiRootPerms,
+			Nlink: 2,
+		}
+		logEntry.Result("attr=%v", attr)
+		return attr, toFuseStatusLog(OK, logEntry)
+	}
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return child.GetAttr(childPath, context)
+}
+
+func (fs *MultiFileSystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		logEntry := StartOperation("OpenDir", name)
+		defer logEntry.FinishOperation()
+
+		names := fs.ChildNames()
+		entries := make([]fuse.DirEntry, len(names))
+		for i, childName := range names {
+			entries[i] = fuse.DirEntry{
+				Name: childName,
+				Mode: fuse.S_IFDIR,
+			}
+		}
+		logEntry.Result("%v", names)
+		return entries, toFuseStatusLog(OK, logEntry)
+	}
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return child.OpenDir(childPath, context)
+}
+
+func (fs *MultiFileSystem) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		if mode&fuse.W_OK == fuse.W_OK {
+			return fuse.Status(syscall.EPERM)
+		}
+		return fuse.OK
+	}
+	if child == nil {
+		return fuse.ENOENT
+	}
+	return child.Access(childPath, mode, context)
+}
+
+func (fs *MultiFileSystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		return "", fuse.EINVAL
+	}
+	if child == nil {
+		return "", fuse.ENOENT
+	}
+	return child.Readlink(childPath, context)
+}
+
+func (fs *MultiFileSystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		return nil, fuse.Status(syscall.EISDIR)
+	}
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return child.Open(childPath, flags, context)
+}
+
+func (fs *MultiFileSystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return nil, status
+	}
+	return child.Create(childPath, flags, mode, context)
+}
+
+func (fs *MultiFileSystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Mkdir(childPath, mode, context)
+}
+
+func (fs *MultiFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Mknod(childPath, mode, dev, context)
+}
+
+func (fs *MultiFileSystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	child, oldPath, newPath, status := fs.routePair(oldName, newName)
+	if !status.Ok() {
+		return status
+	}
+	return child.Rename(oldPath, newPath, context)
+}
+
+func (fs *MultiFileSystem) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	child, oldPath, newPath, status := fs.routePair(oldName, newName)
+	if !status.Ok() {
+		return status
+	}
+	return child.Link(oldPath, newPath, context)
+}
+
+func (fs *MultiFileSystem) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(linkName)
+	if !status.Ok() {
+		return status
+	}
+	return child.Symlink(value, childPath, context)
+}
+
+func (fs *MultiFileSystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Rmdir(childPath, context)
+}
+
+func (fs *MultiFileSystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Unlink(childPath, context)
+}
+
+func (fs *MultiFileSystem) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Chmod(childPath, mode, context)
+}
+
+func (fs *MultiFileSystem) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Chown(childPath, uid, gid, context)
+}
+
+func (fs *MultiFileSystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Truncate(childPath, size, context)
+}
+
+func (fs *MultiFileSystem) Utimens(name string, Atime *time.Time, Mtime *time.Time, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.Utimens(childPath, Atime, Mtime, context)
+}
+
+func (fs *MultiFileSystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		return nil, fuse.ENOSYS
+	}
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return child.GetXAttr(childPath, attribute, context)
+}
+
+func (fs *MultiFileSystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		return nil, fuse.ENOSYS
+	}
+	if child == nil {
+		return nil, fuse.ENOENT
+	}
+	return child.ListXAttr(childPath, context)
+}
+
+func (fs *MultiFileSystem) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.RemoveXAttr(childPath, attr, context)
+}
+
+func (fs *MultiFileSystem) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	child, childPath, status := fs.routeModification(name)
+	if !status.Ok() {
+		return status
+	}
+	return child.SetXAttr(childPath, attr, data, flags, context)
+}
+
+func (fs *MultiFileSystem) StatFs(name string) *fuse.StatfsOut {
+	child, childPath, isRoot := fs.route(name)
+	if isRoot {
+		// The root doesn't live on any device filesystem.
+		return &fuse.StatfsOut{}
+	}
+	if child == nil {
+		return nil
+	}
+	return child.StatFs(childPath)
+}
+
+func (fs *MultiFileSystem) OnMount(nodeFs *pathfs.PathNodeFs) {
+	fs.forEachChild(func(_ string, child pathfs.FileSystem) {
+		// Notifying children get a notifier for their own paths instead.
+		if _, ok := child.(notifyingFileSystem); !ok {
+			child.OnMount(nodeFs)
+		}
+	})
+	fs.setNotifier(nodeFs)
+}
+
+func (fs *MultiFileSystem) setNotifier(notifier Notifier) {
+	fs.lock.Lock()
+	fs.notifier = notifier
+	fs.lock.Unlock()
+
+	fs.forEachChild(func(name string, child pathfs.FileSystem) {
+		if child, ok := child.(notifyingFileSystem); ok {
+			child.setNotifier(prefixNotifier{notifier, name})
+		}
+	})
+}
+
+func (fs *MultiFileSystem) OnUnmount() {
+	fs.forEachChild(func(_ string, child pathfs.FileSystem) {
+		child.OnUnmount()
+	})
+}
+
+func (fs *MultiFileSystem) forEachChild(f func(name string, child pathfs.FileSystem)) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	for _, name := range fs.names {
+		f(name, fs.children[name])
+	}
+}
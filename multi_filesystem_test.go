@@ -0,0 +1,116 @@
+package adbfs
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingFileSystem records the paths passed to it.
+type recordingFileSystem struct {
+	pathfs.FileSystem
+	names []string
+}
+
+func newRecordingFileSystem() *recordingFileSystem {
+	return &recordingFileSystem{FileSystem: pathfs.NewDefaultFileSystem()}
+}
+
+func (fs *recordingFileSystem) GetAttr(name string, _ *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.names = append(fs.names, name)
+	return &fuse.Attr{}, fuse.OK
+}
+
+func (fs *recordingFileSystem) OpenDir(name string, _ *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	fs.names = append(fs.names, name)
+	return []fuse.DirEntry{{Name: "foo", Mode: fuse.S_IFREG}}, fuse.OK
+}
+
+func (fs *recordingFileSystem) Mkdir(name string, _ uint32, _ *fuse.Context) fuse.Status {
+	fs.names = append(fs.names, name)
+	return fuse.OK
+}
+
+func (fs *recordingFileSystem) Rename(oldName, newName string, _ *fuse.Context) fuse.Status {
+	fs.names = append(fs.names, oldName, newName)
+	return fuse.OK
+}
+
+func TestMultiFileSystem_Root(t *testing.T) {
+	fs := NewMultiFileSystem()
+	fs.AddChild("sdcard", newRecordingFileSystem())
+	fs.AddChild("tmp", newRecordingFileSystem())
+
+	attr, status := fs.GetAttr("", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.True(t, attr.IsDir())
+
+	entries, status := fs.OpenDir("", nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "sdcard", Mode: fuse.S_IFDIR},
+		{Name: "tmp", Mode: fuse.S_IFDIR},
+	}, entries)
+}
+
+func TestMultiFileSystem_RoutesToChild(t *testing.T) {
+	fs := NewMultiFileSystem()
+	sdcard := newRecordingFileSystem()
+	tmp := newRecordingFileSystem()
+	fs.AddChild("sdcard", sdcard)
+	fs.AddChild("tmp", tmp)
+
+	fs.GetAttr("sdcard", nil)
+	fs.GetAttr("sdcard/DCIM/foo.jpg", nil)
+	fs.Mkdir("tmp/bar", 0755, nil)
+	fs.Rename("tmp/bar", "tmp/baz", nil)
+
+	assert.Equal(t, []string{"", "DCIM/foo.jpg"}, sdcard.names)
+	assert.Equal(t, []string{"bar", "bar", "baz"}, tmp.names)
+}
+
+func TestMultiFileSystem_NoSuchChild(t *testing.T) {
+	fs := NewMultiFileSystem()
+	fs.AddChild("sdcard", newRecordingFileSystem())
+
+	_, status := fs.GetAttr("foo", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+	_, status = fs.GetAttr("foo/bar", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
+
+func TestMultiFileSystem_RootNotWritable(t *testing.T) {
+	fs := NewMultiFileSystem()
+	fs.AddChild("sdcard", newRecordingFileSystem())
+
+	assert.Equal(t, fuse.Status(syscall.EPERM), fs.Mkdir("foo", 0755, nil))
+	assert.Equal(t, fuse.Status(syscall.EPERM), fs.Rmdir("sdcard", nil))
+	assert.Equal(t, fuse.Status(syscall.EPERM), fs.Rename("sdcard", "foo", nil))
+	assert.Equal(t, fuse.Status(syscall.EPERM), fs.Access("", fuse.W_OK, nil))
+	assert.Equal(t, fuse.OK, fs.Access("", fuse.R_OK, nil))
+}
+
+func TestMultiFileSystem_RenameAcrossChildren(t *testing.T) {
+	fs := NewMultiFileSystem()
+	fs.AddChild("sdcard", newRecordingFileSystem())
+	fs.AddChild("tmp", newRecordingFileSystem())
+
+	assert.Equal(t, fuse.Status(syscall.EXDEV), fs.Rename("sdcard/foo", "tmp/foo", nil))
+}
+
+func TestMultiFileSystem_RemoveChild(t *testing.T) {
+	fs := NewMultiFileSystem()
+	sdcard := newRecordingFileSystem()
+	fs.AddChild("sdcard", sdcard)
+	fs.AddChild("tmp", newRecordingFileSystem())
+
+	assert.Equal(t, sdcard, fs.RemoveChild("sdcard"))
+	assert.Nil(t, fs.RemoveChild("sdcard"))
+	assert.Equal(t, []string{"tmp"}, fs.ChildNames())
+
+	_, status := fs.GetAttr("sdcard", nil)
+	assert.Equal(t, fuse.ENOENT, status)
+}
@@ -0,0 +1,46 @@
+package adbfs
+
+import (
+	"path"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+/*
+Notifier tells the kernel that files changed, so it drops the entries and attributes it has cached
+for them. Paths are relative to the root of the mount.
+
+Notifying the kernel from inside an operation on the same directory may deadlock, since the kernel
+can be holding locks while it waits for the operation to return.
+*/
+type Notifier interface {
+	// EntryNotify makes the kernel look name in dir up again the next time it's accessed.
+	EntryNotify(dir string, name string) fuse.Status
+	// FileNotify drops the cached contents of path from off for length bytes, or all of them if
+	// length is 0.
+	FileNotify(path string, off int64, length int64) fuse.Status
+}
+
+var _ Notifier = &pathfs.PathNodeFs{}
+
+// notifyingFileSystem is implemented by filesystems that notify the kernel of changes, so
+// filesystems that contain them can give them a Notifier for their own paths.
+type notifyingFileSystem interface {
+	setNotifier(notifier Notifier)
+}
+
+// prefixNotifier is the Notifier for a filesystem that's mounted in the Prefix directory of
+// another.
+type prefixNotifier struct {
+	Notifier
+	Prefix string
+}
+
+func (n prefixNotifier) EntryNotify(dir string, name string) fuse.Status {
+	return n.Notifier.EntryNotify(path.Join(n.Prefix, dir), name)
+}
+
+func (n prefixNotifier) FileNotify(name string, off int64, length int64) fuse.Status {
+	return n.Notifier.FileNotify(path.Join(n.Prefix, name), off, length)
+}
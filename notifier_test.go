@@ -0,0 +1,101 @@
+package adbfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingNotifier sends every notification it receives to C.
+type recordingNotifier struct {
+	C chan string
+}
+
+func newRecordingNotifier() *recordingNotifier {
+	return &recordingNotifier{C: make(chan string, 100)}
+}
+
+func (n *recordingNotifier) EntryNotify(dir string, name string) fuse.Status {
+	n.C <- fmt.Sprintf("entry %s %s", dir, name)
+	return fuse.OK
+}
+
+func (n *recordingNotifier) FileNotify(path string, off int64, length int64) fuse.Status {
+	n.C <- fmt.Sprintf("file %s %d %d", path, off, length)
+	return fuse.OK
+}
+
+// Next returns the next notification, or fails if none is received soon.
+func (n *recordingNotifier) Next(t *testing.T) string {
+	select {
+	case notification := <-n.C:
+		return notification
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for notification")
+		return ""
+	}
+}
+
+// notifyingRecordingFileSystem records the Notifier it's given.
+type notifyingRecordingFileSystem struct {
+	*recordingFileSystem
+	notifier Notifier
+}
+
+func (fs *notifyingRecordingFileSystem) setNotifier(notifier Notifier) {
+	fs.notifier = notifier
+}
+
+func TestPrefixNotifier(t *testing.T) {
+	recorder := newRecordingNotifier()
+	notifier := prefixNotifier{recorder, "sdcard"}
+
+	notifier.EntryNotify("", "foo")
+	notifier.EntryNotify("DCIM", "foo.jpg")
+	notifier.FileNotify("DCIM/foo.jpg", 0, 0)
+
+	assert.Equal(t, "entry sdcard foo", recorder.Next(t))
+	assert.Equal(t, "entry sdcard/DCIM foo.jpg", recorder.Next(t))
+	assert.Equal(t, "file sdcard/DCIM/foo.jpg 0 0", recorder.Next(t))
+}
+
+func TestMultiFileSystem_SetNotifier(t *testing.T) {
+	recorder := newRecordingNotifier()
+	fs := NewMultiFileSystem()
+	sdcard := &notifyingRecordingFileSystem{recordingFileSystem: newRecordingFileSystem()}
+	fs.AddChild("sdcard", sdcard)
+	fs.AddChild("plain", newRecordingFileSystem())
+
+	fs.setNotifier(recorder)
+	assert.Equal(t, prefixNotifier{recorder, "sdcard"}, sdcard.notifier)
+
+	// Children added after mounting get a notifier too.
+	tmp := &notifyingRecordingFileSystem{recordingFileSystem: newRecordingFileSystem()}
+	fs.AddChild("tmp", tmp)
+	assert.Equal(t, prefixNotifier{recorder, "tmp"}, tmp.notifier)
+}
+
+func TestMultiFileSystem_NotifiesChildChanges(t *testing.T) {
+	recorder := newRecordingNotifier()
+	fs := NewMultiFileSystem()
+	fs.setNotifier(recorder)
+
+	fs.AddChild("sdcard", newRecordingFileSystem())
+	assert.Equal(t, "entry  sdcard", recorder.Next(t))
+	assert.Equal(t, "file  0 0", recorder.Next(t))
+
+	fs.RemoveChild("sdcard")
+	assert.Equal(t, "entry  sdcard", recorder.Next(t))
+	assert.Equal(t, "file  0 0", recorder.Next(t))
+
+	// Removing a child that doesn't exist doesn't change anything.
+	fs.RemoveChild("sdcard")
+	select {
+	case notification := <-recorder.C:
+		t.Fatal("unexpected notification:", notification)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -15,6 +15,9 @@ type OpenFilesOptions struct {
 
 	// The length of time the file can be dirty before the next write will force a flush.
 	DirtyTimeout time.Duration
+
+	// If not nil, called with the path of a file after its contents are saved to the device.
+	FileSavedHandler func(path string)
 }
 
 // OpenFiles tracks and manages the set of all open files in a filesystem.
@@ -46,6 +49,7 @@ func (f *OpenFiles) GetOrLoad(path string, openFlags FileOpenFlags, perms os.Fil
 			Client:              f.ClientFactory(),
 			DirtyTimeout:        f.DirtyTimeout,
 			Perms:               perms,
+			SavedHandler:        f.saved,
 			ZeroRefCountHandler: f.release,
 		}, logEntry)
 		if err != nil {
@@ -61,6 +65,27 @@ func (f *OpenFiles) GetOrLoad(path string, openFlags FileOpenFlags, perms os.Fil
 	return file, nil
 }
 
+// Close discards the buffers of all open files, e.g. because the device is gone.
+// Unsaved changes are lost.
+func (f *OpenFiles) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for path, file := range f.buffersByPath {
+		if file.IsDirty() {
+			cli.Log.Warnln("OpenFiles: discarding unsaved changes to", path)
+		}
+		file.Discard()
+	}
+	f.buffersByPath = make(map[string]*FileBuffer)
+}
+
+func (f *OpenFiles) saved(file *FileBuffer) {
+	if f.FileSavedHandler != nil {
+		f.FileSavedHandler(file.Path)
+	}
+}
+
 func (f *OpenFiles) release(file *FileBuffer) {
 	// Acquire the lock first, so that a concurrent call to GetOrLoad won't be able to increment
 	// the refcount before we remove it from the map.
@@ -64,3 +64,31 @@ func TestOpenFiles_GetOrLoadSameFileShared(t *testing.T) {
 	assert.Equal(t, 2, f3.RefCount())
 	assert.Equal(t, 2, f2.RefCount())
 }
+
+func TestOpenFiles_Close(t *testing.T) {
+	dev := &delegateDeviceClient{
+		stat: statFiles(&adb.DirEntry{
+			Name: "/",
+		}),
+		openRead: openReadString("hello"),
+	}
+	o := NewOpenFiles(OpenFilesOptions{
+		DeviceSerial:  "abc",
+		ClientFactory: func() DeviceClient { return dev },
+	})
+
+	f1, err := o.GetOrLoad("/", O_RDWR, 0, &LogEntry{})
+	assert.NoError(t, err)
+	f1.WriteAt([]byte("world"), 0)
+
+	o.Close()
+	assert.Equal(t, int64(0), f1.Size())
+	assert.False(t, f1.IsDirty())
+
+	// Handles that are still open can be released.
+	f1.DecRefCount()
+
+	f2, err := o.GetOrLoad("/", O_RDONLY, 0, &LogEntry{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, f1, f2)
+}
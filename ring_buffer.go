@@ -0,0 +1,75 @@
+package adbfs
+
+// RingBuffer is an append-only byte stream that only retains its most recent bytes.
+// Offsets are absolute positions in the stream, so they stay valid as old data is discarded.
+// It is not safe for concurrent use.
+type RingBuffer struct {
+	data []byte
+	// Total number of bytes ever written.
+	size int64
+}
+
+func NewRingBuffer(capacity int) *RingBuffer {
+	return &RingBuffer{
+		data: make([]byte, capacity),
+	}
+}
+
+// Size returns the total number of bytes ever written.
+func (b *RingBuffer) Size() int64 {
+	return b.size
+}
+
+// Start returns the offset of the oldest byte still retained.
+func (b *RingBuffer) Start() int64 {
+	if b.size > int64(len(b.data)) {
+		return b.size - int64(len(b.data))
+	}
+	return 0
+}
+
+func (b *RingBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > len(b.data) {
+		// Only the tail will fit.
+		b.size += int64(len(p) - len(b.data))
+		p = p[len(p)-len(b.data):]
+	}
+
+	for len(p) > 0 {
+		i := int(b.size % int64(len(b.data)))
+		copied := copy(b.data[i:], p)
+		p = p[copied:]
+		b.size += int64(copied)
+	}
+	return n, nil
+}
+
+// ReadAt copies bytes starting at off into p, and returns the number of bytes copied.
+// Bytes that have already been discarded are filled with fill. If off is at or past the end of
+// the stream, 0 is returned.
+func (b *RingBuffer) ReadAt(p []byte, off int64, fill byte) int {
+	if off >= b.size {
+		return 0
+	}
+	if remaining := b.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n := 0
+	for start := b.Start(); n < len(p) && off < start; n, off = n+1, off+1 {
+		p[n] = fill
+	}
+	for n < len(p) {
+		i := int(off % int64(len(b.data)))
+		copied := copy(p[n:], b.data[i:])
+		n += copied
+		off += int64(copied)
+	}
+	return len(p)
+}
+
+// Reset discards all data and resets the size to 0.
+func (b *RingBuffer) Reset() {
+	b.size = 0
+}
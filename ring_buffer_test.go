@@ -0,0 +1,58 @@
+package adbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer_ReadAt(t *testing.T) {
+	b := NewRingBuffer(8)
+	b.Write([]byte("hello"))
+
+	buf := make([]byte, 10)
+	n := b.ReadAt(buf, 0, '-')
+	assert.Equal(t, "hello", string(buf[:n]))
+	n = b.ReadAt(buf, 3, '-')
+	assert.Equal(t, "lo", string(buf[:n]))
+	assert.Equal(t, 0, b.ReadAt(buf, 5, '-'))
+	assert.Equal(t, 0, b.ReadAt(buf, 10, '-'))
+}
+
+func TestRingBuffer_Wraps(t *testing.T) {
+	b := NewRingBuffer(8)
+	b.Write([]byte("hello"))
+	b.Write([]byte(" world"))
+
+	assert.Equal(t, int64(11), b.Size())
+	assert.Equal(t, int64(3), b.Start())
+
+	buf := make([]byte, 20)
+	n := b.ReadAt(buf, 0, '-')
+	assert.Equal(t, "---lo world", string(buf[:n]))
+	n = b.ReadAt(buf, 6, '-')
+	assert.Equal(t, "world", string(buf[:n]))
+}
+
+func TestRingBuffer_WriteLargerThanCapacity(t *testing.T) {
+	b := NewRingBuffer(4)
+	b.Write([]byte("ab"))
+	b.Write([]byte("0123456789"))
+
+	assert.Equal(t, int64(12), b.Size())
+	buf := make([]byte, 4)
+	n := b.ReadAt(buf, 8, '-')
+	assert.Equal(t, "6789", string(buf[:n]))
+}
+
+func TestRingBuffer_Reset(t *testing.T) {
+	b := NewRingBuffer(4)
+	b.Write([]byte("hello"))
+	b.Reset()
+
+	assert.Equal(t, int64(0), b.Size())
+	b.Write([]byte("ab"))
+	buf := make([]byte, 4)
+	n := b.ReadAt(buf, 0, '-')
+	assert.Equal(t, "ab", string(buf[:n]))
+}
@@ -0,0 +1,142 @@
+package adbfs
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+)
+
+// RootMethod describes how RootDeviceClientFactory gets root access on the device.
+type RootMethod int
+
+const (
+	// Root is not available, so operations run as the shell user.
+	RootUnavailable RootMethod = iota
+	// adbd is already running as root (adb root), so no wrapping is needed. ProbeRootMethod never
+	// runs adb root itself, since restarting adbd drops the device's connection.
+	RootAdbd
+	// su from AOSP userdebug builds, which takes the user and the command as separate arguments.
+	RootSuUser
+	// su from SuperSU, Magisk, etc., which takes a single command string with -c.
+	RootSuCommand
+)
+
+func (m RootMethod) String() string {
+	switch m {
+	case RootUnavailable:
+		return "unavailable"
+	case RootAdbd:
+		return "adb root"
+	case RootSuUser:
+		return "su 0"
+	case RootSuCommand:
+		return "su -c"
+	}
+	return "unknown"
+}
+
+/*
+NewRootDeviceClientFactory returns a factory that creates DeviceClients that operate as root.
+
+The first time a client is created, the device is probed to find out how to get root.
+If adbd is already running as root, clients from factory are returned as-is. Otherwise, if su is
+available, clients are wrapped in a ShellDeviceClient that runs every command through su.
+If root isn't available at all, a warning is logged and clients fall back to operating as the
+shell user.
+*/
+func NewRootDeviceClientFactory(factory DeviceClientFactory) DeviceClientFactory {
+	var once sync.Once
+	var wrap CommandWrapper
+
+	return func() DeviceClient {
+		client := factory()
+
+		once.Do(func() {
+			method := ProbeRootMethod(client)
+			if method == RootUnavailable {
+				cli.Log.Warnln("root access requested but not available, falling back to shell user")
+			} else {
+				cli.Log.Infoln("root access method:", method)
+			}
+			wrap = method.commandWrapper()
+		})
+
+		if wrap == nil {
+			return client
+		}
+		return &ShellDeviceClient{
+			DeviceClient: client,
+			Wrap:         wrap,
+		}
+	}
+}
+
+// ProbeRootMethod checks which method, if any, can be used to run commands as root on the device.
+// It only detects whether adbd is already running as root; it doesn't try to restart it as root.
+func ProbeRootMethod(client DeviceClient) RootMethod {
+	if isRootUid(client.RunCommand("id", "-u")) {
+		return RootAdbd
+	}
+
+	cmd, args := RootSuUser.commandWrapper()("id", []string{"-u"})
+	if isRootUid(client.RunCommand(cmd, args...)) {
+		return RootSuUser
+	}
+
+	cmd, args = RootSuCommand.commandWrapper()("id", []string{"-u"})
+	if isRootUid(client.RunCommand(cmd, args...)) {
+		return RootSuCommand
+	}
+
+	return RootUnavailable
+}
+
+func isRootUid(output string, err error) bool {
+	return err == nil && strings.TrimSpace(output) == "0"
+}
+
+// commandWrapper returns the CommandWrapper used to run commands with m, or nil if commands
+// don't need to be wrapped.
+func (m RootMethod) commandWrapper() CommandWrapper {
+	switch m {
+	case RootSuUser:
+		return func(cmd string, args []string) (string, []string) {
+			return "su", append([]string{"0", cmd}, args...)
+		}
+	case RootSuCommand:
+		return func(cmd string, args []string) (string, []string) {
+			// Arguments that contain whitespace are already escaped for goadb's double quotes, but
+			// joinShellCommand escapes the whole command again, so undo the first escaping.
+			unescaped := make([]string, len(args))
+			for i, arg := range args {
+				if strings.ContainsAny(arg, " \t\n") {
+					arg = doubleQuoteUnescaper.Replace(arg)
+				}
+				unescaped[i] = arg
+			}
+			return "su", []string{"-c", joinShellCommand(cmd, unescaped)}
+		}
+	}
+	return nil
+}
+
+// joinShellCommand quotes cmd and args into a single string that can be passed to sh -c.
+func joinShellCommand(cmd string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	words = append(words, quoteShellArg(cmd))
+	for _, arg := range args {
+		words = append(words, quoteShellArg(arg))
+	}
+	command := strings.Join(words, " ")
+
+	// goadb wraps arguments that contain whitespace in double quotes, so characters that are
+	// special inside double quotes must be escaped to reach su intact.
+	if strings.ContainsAny(command, " \t\n") {
+		command = doubleQuoteEscaper.Replace(command)
+	}
+	return command
+}
+
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, "`", "\\`")
+var doubleQuoteUnescaper = strings.NewReplacer(`\\`, `\`, `\$`, `$`, "\\`", "`")
@@ -0,0 +1,99 @@
+package adbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeRootMethod(t *testing.T) {
+	for _, test := range []struct {
+		RootCommand string
+		Expected    RootMethod
+	}{
+		{"id -u", RootAdbd},
+		{"su 0 id -u", RootSuUser},
+		{"su -c 'id' '-u'", RootSuCommand},
+		{"", RootUnavailable},
+	} {
+		client := &delegateDeviceClient{
+			runCommand: runCommandAsRoot(test.RootCommand),
+		}
+		assert.Equal(t, test.Expected, ProbeRootMethod(client), "%s", test.RootCommand)
+	}
+}
+
+func TestRootDeviceClientFactory_ProbesOnce(t *testing.T) {
+	var probes int
+	factory := NewRootDeviceClientFactory(func() DeviceClient {
+		return &delegateDeviceClient{
+			runCommand: func(cmd string, args []string) (string, error) {
+				probes++
+				return "0\n", nil
+			},
+		}
+	})
+
+	factory()
+	factory()
+	assert.Equal(t, 1, probes)
+}
+
+func TestRootDeviceClientFactory_Fallback(t *testing.T) {
+	delegate := &delegateDeviceClient{
+		runCommand: runCommandAsRoot(""),
+	}
+	client := NewRootDeviceClientFactory(func() DeviceClient {
+		return delegate
+	})()
+	assert.Equal(t, delegate, client)
+}
+
+func TestRootDeviceClientFactory_WrapsWithSu(t *testing.T) {
+	var commands []string
+	client := NewRootDeviceClientFactory(func() DeviceClient {
+		return &delegateDeviceClient{
+			runCommand: func(cmd string, args []string) (string, error) {
+				commandLine := cmd + " " + strings.Join(args, " ")
+				commands = append(commands, commandLine)
+				return runCommandAsRoot("su 0 id -u")(cmd, args)
+			},
+		}
+	})()
+
+	commands = nil
+	client.RunCommand("ls", "/data")
+	assert.Equal(t, []string{"su 0 ls /data"}, commands)
+}
+
+func TestRootDeviceClientFactory_SuCommandEscapesOnce(t *testing.T) {
+	var commands []string
+	client := NewRootDeviceClientFactory(func() DeviceClient {
+		return &delegateDeviceClient{
+			runCommand: recordCommands(&commands, runCommandAsRoot("su -c 'id' '-u'")),
+		}
+	})()
+
+	commands = nil
+	client.Stat("/data/$x", &LogEntry{})
+	assert.Equal(t, []string{
+		`su -c 'sh' '-c' 'stat -c '\\''%f:%s:%Y:%n'\\'' '\\''/data/\$x'\\'''`,
+	}, commands)
+}
+
+func TestJoinShellCommand(t *testing.T) {
+	assert.Equal(t, `'ls'`, joinShellCommand("ls", nil))
+	assert.Equal(t, `'ls' '/data/it'\\''s'`, joinShellCommand("ls", []string{"/data/it's"}))
+	assert.Equal(t, "'cat' '\\$HOME/\\`x\\`'", joinShellCommand("cat", []string{"$HOME/`x`"}))
+}
+
+// runCommandAsRoot returns a runCommand func that prints uid 0 only for rootCommand.
+func runCommandAsRoot(rootCommand string) func(cmd string, args []string) (string, error) {
+	return func(cmd string, args []string) (string, error) {
+		if cmd+" "+strings.Join(args, " ") == rootCommand {
+			return "0\n", nil
+		}
+		return "2000\n", nil
+	}
+}
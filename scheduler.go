@@ -0,0 +1,184 @@
+package adbfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OperationClass determines how the Scheduler prioritizes an operation.
+type OperationClass int
+
+const (
+	// Short operations that don't transfer file contents, e.g. stat, listing directories,
+	// reading links, and running commands.
+	MetadataOperation OperationClass = iota
+
+	// Long operations that transfer file contents to or from the device.
+	BulkOperation
+
+	// Commands that may run for a long time, e.g. screencap, find, and pm install. They're limited
+	// like bulk operations, but bulk operations don't pause for them.
+	CommandOperation
+
+	numOperationClasses
+)
+
+// Default for SchedulerOptions.MaxMetadataBurst.
+const DefaultMaxMetadataBurst = 16
+
+func (c OperationClass) String() string {
+	switch c {
+	case MetadataOperation:
+		return "metadata"
+	case BulkOperation:
+		return "bulk"
+	case CommandOperation:
+		return "command"
+	default:
+		return fmt.Sprintf("OperationClass(%d)", int(c))
+	}
+}
+
+type SchedulerOptions struct {
+	// Maximum number of metadata operations that may run at the same time.
+	// Values <1 are treated as 1.
+	MaxMetadataOperations int
+
+	// Maximum number of file transfers that may be open at the same time, and of long-running
+	// commands that may run at the same time. The two are counted separately.
+	// Values <1 are treated as 1.
+	MaxBulkOperations int
+
+	// Maximum number of metadata operations that may finish while a bulk operation is waiting for
+	// them, after which it continues anyway, so constant browsing can't stall a transfer forever.
+	// Values <1 are treated as DefaultMaxMetadataBurst.
+	MaxMetadataBurst int
+}
+
+/*
+Scheduler limits the number of concurrent operations of each OperationClass sent to the adb server,
+and gives metadata operations priority over bulk transfers.
+
+A bulk operation holds its slot for as long as its stream is open. Before it starts, and before every
+chunk it reads or writes, it waits until no metadata operations are running or queued. This means a
+large transfer pauses while the filesystem is being browsed, instead of making every stat and
+directory listing queue behind it on the adb server. It only waits for MaxMetadataBurst metadata
+operations to finish though, so it still makes progress while something is scanning the filesystem.
+*/
+type Scheduler struct {
+	SchedulerOptions
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	running [numOperationClasses]int
+	waiting [numOperationClasses]int
+	// Total number of metadata operations that have finished, used to bound how long bulk
+	// operations wait for them.
+	metadataFinished int
+}
+
+func NewScheduler(opts SchedulerOptions) *Scheduler {
+	if opts.MaxMetadataOperations < 1 {
+		opts.MaxMetadataOperations = 1
+	}
+	if opts.MaxBulkOperations < 1 {
+		opts.MaxBulkOperations = 1
+	}
+	if opts.MaxMetadataBurst < 1 {
+		opts.MaxMetadataBurst = DefaultMaxMetadataBurst
+	}
+
+	s := &Scheduler{
+		SchedulerOptions: opts,
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Acquire blocks until an operation of class is allowed to run, and returns a function that must
+// be called when the operation is finished.
+// If the operation had to wait, the time spent waiting is recorded on log, if it's not nil.
+func (s *Scheduler) Acquire(class OperationClass, log *LogEntry) (release func()) {
+	startTime := time.Now()
+	waited := false
+
+	s.lock.Lock()
+	s.waiting[class]++
+	since := s.metadataFinished
+	for !s.canStart(class, since) {
+		waited = true
+		s.cond.Wait()
+	}
+	s.waiting[class]--
+	s.running[class]++
+	s.lock.Unlock()
+
+	if waited {
+		recordQueueWait(log, startTime)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.running[class]--
+			if class == MetadataOperation {
+				s.metadataFinished++
+			}
+			s.cond.Broadcast()
+		})
+	}
+}
+
+// Yield blocks until no metadata operations are running or waiting to run, or MaxMetadataBurst of
+// them have finished. Bulk operations should call this before transferring every chunk.
+func (s *Scheduler) Yield(log *LogEntry) {
+	startTime := time.Now()
+	waited := false
+
+	s.lock.Lock()
+	since := s.metadataFinished
+	for s.mustYield(since) {
+		waited = true
+		s.cond.Wait()
+	}
+	s.lock.Unlock()
+
+	if waited {
+		recordQueueWait(log, startTime)
+	}
+}
+
+func (s *Scheduler) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return fmt.Sprintf("Scheduler{running=%v, waiting=%v}", s.running, s.waiting)
+}
+
+// canStart must be called with lock held. since is the value of metadataFinished when the
+// operation started waiting.
+func (s *Scheduler) canStart(class OperationClass, since int) bool {
+	switch class {
+	case MetadataOperation:
+		return s.running[MetadataOperation] < s.MaxMetadataOperations
+	case BulkOperation, CommandOperation:
+		return s.running[class] < s.MaxBulkOperations && !s.mustYield(since)
+	default:
+		panic("invalid operation class: " + class.String())
+	}
+}
+
+// mustYield returns true if a bulk operation that started waiting when metadataFinished was since
+// should keep waiting for metadata operations. It must be called with lock held.
+func (s *Scheduler) mustYield(since int) bool {
+	metadataBusy := s.running[MetadataOperation] > 0 || s.waiting[MetadataOperation] > 0
+	return metadataBusy && s.metadataFinished-since < s.MaxMetadataBurst
+}
+
+func recordQueueWait(log *LogEntry, startTime time.Time) {
+	if log != nil {
+		log.QueueWaited(time.Since(startTime))
+	}
+}
@@ -0,0 +1,210 @@
+package adbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// How long to wait before deciding that a goroutine is blocked.
+const schedulerTestTimeout = 50 * time.Millisecond
+
+func TestScheduler_MetadataLimit(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 2})
+
+	release1 := s.Acquire(MetadataOperation, &LogEntry{})
+	release2 := s.Acquire(MetadataOperation, &LogEntry{})
+
+	acquired := acquireAsync(s, MetadataOperation)
+	assertBlocked(t, acquired)
+
+	release1()
+	assertNotBlocked(t, acquired)
+	release2()
+}
+
+func TestScheduler_BulkLimit(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxBulkOperations: 1})
+
+	release := s.Acquire(BulkOperation, &LogEntry{})
+
+	acquired := acquireAsync(s, BulkOperation)
+	assertBlocked(t, acquired)
+
+	release()
+	assertNotBlocked(t, acquired)
+}
+
+func TestScheduler_BulkWaitsForMetadata(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+
+	release := s.Acquire(MetadataOperation, &LogEntry{})
+
+	acquired := acquireAsync(s, BulkOperation)
+	assertBlocked(t, acquired)
+
+	release()
+	assertNotBlocked(t, acquired)
+}
+
+func TestScheduler_MetadataDoesntWaitForBulk(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+
+	release := s.Acquire(BulkOperation, &LogEntry{})
+	defer release()
+
+	assertNotBlocked(t, acquireAsync(s, MetadataOperation))
+}
+
+func TestScheduler_YieldWaitsForMetadata(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+	releaseBulk := s.Acquire(BulkOperation, &LogEntry{})
+	defer releaseBulk()
+
+	releaseMetadata := s.Acquire(MetadataOperation, &LogEntry{})
+
+	log := &LogEntry{}
+	yielded := make(chan struct{})
+	go func() {
+		s.Yield(log)
+		close(yielded)
+	}()
+	assertBlocked(t, yielded)
+
+	releaseMetadata()
+	assertNotBlocked(t, yielded)
+	assert.Equal(t, 1, log.queueWaits)
+}
+
+func TestScheduler_YieldContinuesUnderMetadataLoad(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 2, MaxMetadataBurst: 3})
+	releaseBulk := s.Acquire(BulkOperation, nil)
+	defer releaseBulk()
+
+	release := s.Acquire(MetadataOperation, nil)
+	yielded := make(chan struct{})
+	go func() {
+		s.Yield(nil)
+		close(yielded)
+	}()
+
+	// Start every operation before the last one finishes, so metadata is never idle.
+	for i := 0; i < 3; i++ {
+		assertBlocked(t, yielded)
+		next := s.Acquire(MetadataOperation, nil)
+		release()
+		release = next
+	}
+	assertNotBlocked(t, yielded)
+	release()
+}
+
+func TestScheduler_BulkStartsUnderMetadataLoad(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 2, MaxMetadataBurst: 2})
+
+	release := s.Acquire(MetadataOperation, nil)
+	acquired := acquireAsync(s, BulkOperation)
+	for i := 0; i < 2; i++ {
+		assertBlocked(t, acquired)
+		next := s.Acquire(MetadataOperation, nil)
+		release()
+		release = next
+	}
+	assertNotBlocked(t, acquired)
+	release()
+}
+
+func TestScheduler_YieldDoesntRecordWhenIdle(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+	log := &LogEntry{}
+
+	s.Yield(log)
+	assert.Equal(t, 0, log.queueWaits)
+}
+
+func TestScheduler_AcquireRecordsWait(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 1})
+	log := &LogEntry{}
+
+	s.Acquire(MetadataOperation, log)()
+	s.Acquire(BulkOperation, log)()
+	assert.Equal(t, 0, log.queueWaits, "uncontended acquires shouldn't record waits")
+
+	release := s.Acquire(MetadataOperation, nil)
+	acquired := make(chan struct{})
+	go func() {
+		s.Acquire(MetadataOperation, log)()
+		close(acquired)
+	}()
+	assertBlocked(t, acquired)
+	release()
+	assertNotBlocked(t, acquired)
+	assert.Equal(t, 1, log.queueWaits)
+}
+
+func TestScheduler_CommandLimit(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxBulkOperations: 1})
+
+	release := s.Acquire(CommandOperation, nil)
+
+	// Commands and transfers are counted separately.
+	releaseBulk := s.Acquire(BulkOperation, nil)
+	releaseBulk()
+
+	acquired := acquireAsync(s, CommandOperation)
+	assertBlocked(t, acquired)
+
+	release()
+	assertNotBlocked(t, acquired)
+}
+
+func TestScheduler_YieldDoesntWaitForCommands(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+	release := s.Acquire(CommandOperation, nil)
+	defer release()
+
+	yielded := make(chan struct{})
+	go func() {
+		s.Yield(nil)
+		close(yielded)
+	}()
+	assertNotBlocked(t, yielded)
+}
+
+func TestScheduler_ReleaseIdempotent(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 1})
+
+	release := s.Acquire(MetadataOperation, nil)
+	release()
+	release()
+
+	release = s.Acquire(MetadataOperation, nil)
+	assertBlocked(t, acquireAsync(s, MetadataOperation))
+	release()
+}
+
+func acquireAsync(s *Scheduler, class OperationClass) <-chan struct{} {
+	acquired := make(chan struct{})
+	go func() {
+		s.Acquire(class, nil)
+		close(acquired)
+	}()
+	return acquired
+}
+
+func assertBlocked(t *testing.T, c <-chan struct{}) {
+	select {
+	case <-c:
+		t.Fatal("expected to be blocked")
+	case <-time.After(schedulerTestTimeout):
+	}
+}
+
+func assertNotBlocked(t *testing.T, c <-chan struct{}) {
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatal("expected not to be blocked")
+	}
+}
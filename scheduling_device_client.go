@@ -0,0 +1,114 @@
+package adbfs
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/zach-klippenstein/goadb"
+)
+
+// SchedulingDeviceClient is a DeviceClient that runs every operation through a Scheduler.
+// Stat, ListDirEntries, and RunCommand are metadata operations, and OpenRead and OpenWrite are
+// bulk operations that hold their slot until the returned stream is closed. RunScheduledCommand
+// runs commands as the class it's given.
+type SchedulingDeviceClient struct {
+	DeviceClient
+	Scheduler *Scheduler
+}
+
+func NewSchedulingDeviceClientFactory(scheduler *Scheduler, factory DeviceClientFactory) DeviceClientFactory {
+	return func() DeviceClient {
+		return &SchedulingDeviceClient{
+			DeviceClient: factory(),
+			Scheduler:    scheduler,
+		}
+	}
+}
+
+func (c *SchedulingDeviceClient) Stat(path string, log *LogEntry) (*adb.DirEntry, error) {
+	defer c.Scheduler.Acquire(MetadataOperation, log)()
+	return c.DeviceClient.Stat(path, log)
+}
+
+func (c *SchedulingDeviceClient) ListDirEntries(path string, log *LogEntry) ([]*adb.DirEntry, error) {
+	defer c.Scheduler.Acquire(MetadataOperation, log)()
+	return c.DeviceClient.ListDirEntries(path, log)
+}
+
+func (c *SchedulingDeviceClient) RunCommand(cmd string, args ...string) (string, error) {
+	return c.RunScheduledCommand(MetadataOperation, nil, cmd, args...)
+}
+
+func (c *SchedulingDeviceClient) RunScheduledCommand(class OperationClass, log *LogEntry, cmd string, args ...string) (string, error) {
+	defer c.Scheduler.Acquire(class, log)()
+	return c.DeviceClient.RunScheduledCommand(class, log, cmd, args...)
+}
+
+func (c *SchedulingDeviceClient) OpenRead(path string, log *LogEntry) (io.ReadCloser, error) {
+	release := c.Scheduler.Acquire(BulkOperation, log)
+	r, err := c.DeviceClient.OpenRead(path, log)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &scheduledReader{
+		ReadCloser: r,
+		scheduler:  c.Scheduler,
+		log:        log,
+		release:    release,
+	}, nil
+}
+
+func (c *SchedulingDeviceClient) OpenWrite(path string, perms os.FileMode, mtime time.Time, log *LogEntry) (io.WriteCloser, error) {
+	release := c.Scheduler.Acquire(BulkOperation, log)
+	w, err := c.DeviceClient.OpenWrite(path, perms, mtime, log)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &scheduledWriter{
+		WriteCloser: w,
+		scheduler:   c.Scheduler,
+		log:         log,
+		release:     release,
+	}, nil
+}
+
+// scheduledReader yields to metadata operations before reading every chunk, and releases its
+// bulk operation slot when closed.
+type scheduledReader struct {
+	io.ReadCloser
+	scheduler *Scheduler
+	log       *LogEntry
+	release   func()
+}
+
+func (r *scheduledReader) Read(buf []byte) (int, error) {
+	r.scheduler.Yield(r.log)
+	return r.ReadCloser.Read(buf)
+}
+
+func (r *scheduledReader) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// scheduledWriter yields to metadata operations before writing every chunk, and releases its
+// bulk operation slot when closed.
+type scheduledWriter struct {
+	io.WriteCloser
+	scheduler *Scheduler
+	log       *LogEntry
+	release   func()
+}
+
+func (w *scheduledWriter) Write(data []byte) (int, error) {
+	w.scheduler.Yield(w.log)
+	return w.WriteCloser.Write(data)
+}
+
+func (w *scheduledWriter) Close() error {
+	defer w.release()
+	return w.WriteCloser.Close()
+}
@@ -0,0 +1,108 @@
+package adbfs
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+)
+
+func TestSchedulingDeviceClient_OpenReadHoldsSlotUntilClose(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxBulkOperations: 1})
+	client := &SchedulingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			openRead: openReadString("hello"),
+		},
+		Scheduler: s,
+	}
+
+	r, err := client.OpenRead("/foo", &LogEntry{})
+	assert.NoError(t, err)
+
+	acquired := acquireAsync(s, BulkOperation)
+	assertBlocked(t, acquired)
+
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	r.Close()
+	assertNotBlocked(t, acquired)
+}
+
+func TestSchedulingDeviceClient_OpenReadErrorReleasesSlot(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxBulkOperations: 1})
+	client := &SchedulingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			openRead: openReadError(ErrNoPermission),
+		},
+		Scheduler: s,
+	}
+
+	_, err := client.OpenRead("/foo", &LogEntry{})
+	assert.Equal(t, ErrNoPermission, err)
+
+	assertNotBlocked(t, acquireAsync(s, BulkOperation))
+}
+
+func TestSchedulingDeviceClient_Stat(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{})
+	client := &SchedulingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			stat: statFiles(&adb.DirEntry{Name: "/foo"}),
+		},
+		Scheduler: s,
+	}
+
+	log := &LogEntry{}
+	entry, err := client.Stat("/foo", log)
+	assert.NoError(t, err)
+	assert.Equal(t, "/foo", entry.Name)
+	assert.Equal(t, 0, log.queueWaits)
+
+	// The slot should have been released.
+	assertNotBlocked(t, acquireAsync(s, BulkOperation))
+}
+
+func TestSchedulingDeviceClient_RunScheduledCommand(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMetadataOperations: 1})
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	client := &SchedulingDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			runCommand: func(cmd string, args []string) (string, error) {
+				if cmd == "screencap" {
+					close(started)
+					<-finish
+				}
+				return "", nil
+			},
+		},
+		Scheduler: s,
+	}
+
+	// A long-running command doesn't hold a metadata slot, or pause transfers.
+	go client.RunScheduledCommand(CommandOperation, nil, "screencap", "-p")
+	<-started
+	yielded := make(chan struct{})
+	go func() {
+		s.Yield(nil)
+		close(yielded)
+	}()
+	assertNotBlocked(t, yielded)
+
+	// Metadata commands record waits when they're blocked.
+	release := s.Acquire(MetadataOperation, nil)
+	log := &LogEntry{}
+	done := make(chan struct{})
+	go func() {
+		client.RunScheduledCommand(MetadataOperation, log, "readlink", "/foo")
+		close(done)
+	}()
+	assertBlocked(t, done)
+	release()
+	assertNotBlocked(t, done)
+	assert.Equal(t, 1, log.queueWaits)
+	close(finish)
+}
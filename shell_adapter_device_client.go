@@ -0,0 +1,78 @@
+package adbfs
+
+import "strings"
+
+// shellAdapter runs its command with args using run, and converts its output to what toolbox would
+// have printed, which is what the helpers that run commands expect.
+type shellAdapter func(run commandRunner, profile *ShellProfile, args []string) (string, error)
+
+// commandRunner runs commands the same way as the call being adapted, so scheduled commands keep
+// their OperationClass and LogEntry.
+type commandRunner func(cmd string, args ...string) (string, error)
+
+// Adapters for each command whose behavior differs between implementations.
+var shellAdapters = map[string]shellAdapter{
+	"readlink": adaptReadlink,
+	"stat":     adaptStat,
+}
+
+/*
+ShellAdapterDeviceClient is a DeviceClient that adapts commands to the shell implementations
+described by Profile. Commands without an adapter are run as-is.
+*/
+type ShellAdapterDeviceClient struct {
+	DeviceClient
+	Profile *ShellProfile
+}
+
+func NewShellAdapterDeviceClientFactory(profile *ShellProfile, factory DeviceClientFactory) DeviceClientFactory {
+	return func() DeviceClient {
+		return &ShellAdapterDeviceClient{
+			DeviceClient: factory(),
+			Profile:      profile,
+		}
+	}
+}
+
+func (c *ShellAdapterDeviceClient) RunCommand(cmd string, args ...string) (string, error) {
+	if adapter, ok := shellAdapters[cmd]; ok {
+		return adapter(c.DeviceClient.RunCommand, c.Profile, args)
+	}
+	return c.DeviceClient.RunCommand(cmd, args...)
+}
+
+func (c *ShellAdapterDeviceClient) RunScheduledCommand(class OperationClass, log *LogEntry, cmd string, args ...string) (string, error) {
+	if adapter, ok := shellAdapters[cmd]; ok {
+		return adapter(func(cmd string, args ...string) (string, error) {
+			return c.DeviceClient.RunScheduledCommand(class, log, cmd, args...)
+		}, c.Profile, args)
+	}
+	return c.DeviceClient.RunScheduledCommand(class, log, cmd, args...)
+}
+
+// adaptReadlink makes readlink print toolbox's error when its argument isn't a link.
+// Toybox and busybox print nothing and exit with an error, which is indistinguishable from an
+// empty target.
+func adaptReadlink(run commandRunner, profile *ShellProfile, args []string) (string, error) {
+	switch profile.Applets["readlink"] {
+	case ShellToybox, ShellBusybox:
+	default:
+		return run("readlink", args...)
+	}
+
+	words := []string{"readlink"}
+	for _, arg := range args {
+		words = append(words, quoteShellArg(arg))
+	}
+	script := strings.Join(words, " ") + " || echo " + quoteShellArg(ReadlinkInvalidArgument)
+	return run("sh", "-c", doubleQuoteEscaper.Replace(script))
+}
+
+// adaptStat fails stat -f without a round trip if it's not supported, so statfs falls back to df
+// immediately.
+func adaptStat(run commandRunner, profile *ShellProfile, args []string) (string, error) {
+	if len(args) > 0 && args[0] == "-f" && !profile.StatFilesystem {
+		return "stat: -f not supported", nil
+	}
+	return run("stat", args...)
+}
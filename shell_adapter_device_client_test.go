@@ -0,0 +1,92 @@
+package adbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShellAdapterTestClient(profile *ShellProfile, output string, commands *[]string) *ShellAdapterDeviceClient {
+	return &ShellAdapterDeviceClient{
+		DeviceClient: &delegateDeviceClient{
+			runCommand: recordCommands(commands, func(string, []string) (string, error) {
+				return output, nil
+			}),
+		},
+		Profile: profile,
+	}
+}
+
+func TestShellAdapterDeviceClient_ReadlinkToybox(t *testing.T) {
+	var commands []string
+	client := newShellAdapterTestClient(&ShellProfile{
+		Applets: map[string]ShellImplementation{"readlink": ShellToybox},
+	}, ReadlinkInvalidArgument+"\n", &commands)
+
+	_, err := readLink(client, "/it's a file", &LogEntry{})
+	assert.Equal(t, ErrNotALink, err)
+	assert.Equal(t, []string{
+		`sh -c readlink '/it'\\''s a file' || echo 'readlink: Invalid argument'`,
+	}, commands)
+}
+
+func TestShellAdapterDeviceClient_ReadlinkToolbox(t *testing.T) {
+	var commands []string
+	client := newShellAdapterTestClient(&ShellProfile{
+		Applets: map[string]ShellImplementation{"readlink": ShellToolbox},
+	}, "/target\r\n", &commands)
+
+	target, err := readLink(client, "/link", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, "/target", target)
+	assert.Equal(t, []string{"readlink /link"}, commands)
+}
+
+func TestShellAdapterDeviceClient_StatFilesystem(t *testing.T) {
+	var commands []string
+	client := newShellAdapterTestClient(&ShellProfile{}, "", &commands)
+
+	output, err := client.RunCommand("stat", "-f", "/sdcard")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, output)
+	assert.Empty(t, commands)
+
+	client.RunCommand("stat", "-c", "%s", "/sdcard")
+	client.RunCommand("mkdir", "/sdcard/dir")
+	assert.Equal(t, []string{"stat -c %s /sdcard", "mkdir /sdcard/dir"}, commands)
+
+	client.Profile.StatFilesystem = true
+	client.RunCommand("stat", "-f", "/sdcard")
+	assert.Equal(t, "stat -f /sdcard", commands[2])
+}
+
+func TestShellAdapterDeviceClient_RunScheduledCommandKeepsClass(t *testing.T) {
+	var commands []string
+	recorder := &scheduledCommandRecorder{
+		DeviceClient: newShellAdapterTestClient(nil, "", &commands).DeviceClient,
+	}
+	client := &ShellAdapterDeviceClient{
+		DeviceClient: recorder,
+		Profile: &ShellProfile{
+			Applets: map[string]ShellImplementation{"readlink": ShellToybox},
+		},
+	}
+	log := &LogEntry{}
+
+	client.RunScheduledCommand(CommandOperation, log, "readlink", "/link")
+	assert.Equal(t, CommandOperation, recorder.class)
+	assert.Equal(t, log, recorder.log)
+	assert.Equal(t, []string{`sh -c readlink '/link' || echo 'readlink: Invalid argument'`}, commands)
+}
+
+// scheduledCommandRecorder records the class and log of the last scheduled command it ran.
+type scheduledCommandRecorder struct {
+	DeviceClient
+	class OperationClass
+	log   *LogEntry
+}
+
+func (c *scheduledCommandRecorder) RunScheduledCommand(class OperationClass, log *LogEntry, cmd string, args ...string) (string, error) {
+	c.class, c.log = class, log
+	return c.DeviceClient.RunScheduledCommand(class, log, cmd, args...)
+}
@@ -0,0 +1,341 @@
+package adbfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+const (
+	// Directory on the device that's writable by the shell user and readable by apps, used to
+	// stage files before copying them into place.
+	ShellTempDir = "/data/local/tmp"
+
+	// Format passed to stat -c: raw mode in hex, size, mtime, and name.
+	// Separated by colons so the format doesn't contain any whitespace that would need quoting.
+	shellStatFormat = "%f:%s:%Y:%n"
+)
+
+// CommandWrapper rewrites a command line so it is executed in a different context on the device
+// (e.g. as a different user).
+type CommandWrapper func(cmd string, args []string) (string, []string)
+
+/*
+ShellDeviceClient is a DeviceClient that performs every operation by running shell commands,
+wrapped by Wrap, through another DeviceClient instead of using the sync service.
+This allows accessing files that the shell user can't, e.g. app-private data via run-as.
+
+Reads are performed with cat, and writes are pushed to ShellTempDir with the sync service and then
+copied into place with cat. RunCommand is also wrapped, so commands run by AdbFileSystem (e.g. mkdir)
+run in the same context.
+
+Note that on older devices that run shell commands in a pty, line endings in files will be mangled.
+*/
+type ShellDeviceClient struct {
+	DeviceClient
+	Wrap CommandWrapper
+}
+
+func NewRunAsDeviceClientFactory(packageName string, factory DeviceClientFactory) DeviceClientFactory {
+	return func() DeviceClient {
+		return &ShellDeviceClient{
+			DeviceClient: factory(),
+			Wrap:         runAsCommandWrapper(packageName),
+		}
+	}
+}
+
+func runAsCommandWrapper(packageName string) CommandWrapper {
+	return func(cmd string, args []string) (string, []string) {
+		return "run-as", append([]string{packageName, cmd}, args...)
+	}
+}
+
+func (c *ShellDeviceClient) RunCommand(cmd string, args ...string) (string, error) {
+	cmd, args = c.Wrap(cmd, args)
+	return c.DeviceClient.RunCommand(cmd, args...)
+}
+
+func (c *ShellDeviceClient) RunScheduledCommand(class OperationClass, log *LogEntry, cmd string, args ...string) (string, error) {
+	cmd, args = c.Wrap(cmd, args)
+	return c.DeviceClient.RunScheduledCommand(class, log, cmd, args...)
+}
+
+// runScript runs script with sh, so paths quoted with quoteShellArg reach the device intact whatever
+// characters they contain.
+func (c *ShellDeviceClient) runScript(script string) (string, error) {
+	return c.RunCommand("sh", "-c", doubleQuoteEscaper.Replace(script))
+}
+
+func (c *ShellDeviceClient) Stat(name string, log *LogEntry) (*adb.DirEntry, error) {
+	output, err := c.runScript(fmt.Sprintf("stat -c %s %s", quoteShellArg(shellStatFormat), quoteShellArg(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseShellStatOutput(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, util.Errorf(util.ParseError, "expected 1 stat result for %s, got %d: %s", name, len(entries), output)
+	}
+
+	// The sync service reports the requested path as the name.
+	entry := entries[0]
+	entry.Name = name
+	return entry, nil
+}
+
+func (c *ShellDeviceClient) ListDirEntries(dir string, log *LogEntry) ([]*adb.DirEntry, error) {
+	// The names are expanded on the device, so large directories don't make the command too long for
+	// adb. Patterns that match nothing are passed to stat literally, so its errors are discarded, and
+	// any output that isn't a stat line is an error from cd.
+	output, err := c.runScript(fmt.Sprintf("cd %s && stat -c %s .* * 2>/dev/null",
+		quoteShellArg(dir), quoteShellArg(shellStatFormat)))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseShellStatOutput(output)
+	if err != nil {
+		return nil, err
+	}
+	entries := parsed[:0]
+	for _, entry := range parsed {
+		entry.Name = path.Base(entry.Name)
+		if entry.Name != "." && entry.Name != ".." {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries, nil
+}
+
+func (c *ShellDeviceClient) OpenRead(name string, log *LogEntry) (io.ReadCloser, error) {
+	// cat reports errors on stdout along with the file contents, so stat first to detect errors.
+	entry, err := c.Stat(name, log)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Mode.IsDir() {
+		return nil, syscall.EISDIR
+	}
+
+	output, err := c.runScript("cat " + quoteShellArg(name))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(output)), nil
+}
+
+func (c *ShellDeviceClient) OpenWrite(name string, perms os.FileMode, mtime time.Time, log *LogEntry) (io.WriteCloser, error) {
+	tempPath := path.Join(ShellTempDir, fmt.Sprintf("adbfs-%d-%s", rand.Int63(), path.Base(name)))
+
+	// The temp file must be readable by whoever the command is wrapped to run as.
+	w, err := c.DeviceClient.OpenWrite(tempPath, 0644, mtime, log)
+	if err != nil {
+		return nil, util.WrapErrf(err, "error opening temp file %s", tempPath)
+	}
+
+	return &shellFileWriter{
+		WriteCloser: w,
+		client:      c,
+		path:        name,
+		tempPath:    tempPath,
+		perms:       perms,
+	}, nil
+}
+
+// shellFileWriter writes to a temp file, and copies the temp file to path when closed.
+type shellFileWriter struct {
+	io.WriteCloser
+	client   *ShellDeviceClient
+	path     string
+	tempPath string
+	perms    os.FileMode
+}
+
+func (w *shellFileWriter) Close() error {
+	if w.client == nil {
+		// Already closed.
+		return nil
+	}
+	client := w.client
+	w.client = nil
+
+	defer client.DeviceClient.RunCommand("rm", "-f", w.tempPath)
+
+	if err := w.WriteCloser.Close(); err != nil {
+		return util.WrapErrf(err, "error writing temp file %s", w.tempPath)
+	}
+
+	script := fmt.Sprintf("cat %s > %s && chmod %o %s",
+		quoteShellArg(w.tempPath), quoteShellArg(w.path), w.perms.Perm(), quoteShellArg(w.path))
+	output, err := client.runScript(script)
+	if err != nil {
+		return err
+	}
+	return parseShellError(output)
+}
+
+// parseShellStatOutput parses the output of stat -c shellStatFormat.
+// Lines that contain error messages (e.g. because a file was deleted between listing and stating
+// it) are skipped, and the first such error is returned along with any parsed entries.
+func parseShellStatOutput(output string) (entries []*adb.DirEntry, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if lineErr := parseShellError(line); lineErr != nil {
+			if err == nil {
+				err = lineErr
+			}
+			continue
+		}
+
+		entry, lineErr := parseShellStatLine(line)
+		if lineErr != nil {
+			return nil, lineErr
+		}
+		entries = append(entries, entry)
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	return entries, err
+}
+
+func parseShellStatLine(line string) (*adb.DirEntry, error) {
+	fields := strings.SplitN(line, ":", 4)
+	if len(fields) != 4 {
+		return nil, util.Errorf(util.ParseError, "invalid stat output: %s", line)
+	}
+
+	rawMode, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return nil, util.Errorf(util.ParseError, "invalid mode in stat output: %s", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, util.Errorf(util.ParseError, "invalid size in stat output: %s", line)
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, util.Errorf(util.ParseError, "invalid mtime in stat output: %s", line)
+	}
+
+	return &adb.DirEntry{
+		Name:       fields[3],
+		Mode:       unixModeToFileMode(uint32(rawMode)),
+		Size:       int32(size),
+		ModifiedAt: time.Unix(mtime, 0),
+	}, nil
+}
+
+// Error messages printed by run-as.
+const (
+	RunAsPackageUnknown       = "unknown"
+	RunAsPackageNotDebuggable = "not debuggable"
+)
+
+// parseShellError returns an error if output contains an error message from a shell command or
+// run-as, else nil.
+func parseShellError(output string) error {
+	firstLine := output
+	if i := strings.IndexByte(output, '\n'); i >= 0 {
+		firstLine = output[:i]
+	}
+	firstLine = strings.TrimSpace(firstLine)
+
+	switch {
+	case strings.HasPrefix(firstLine, "run-as:") && strings.Contains(firstLine, RunAsPackageUnknown):
+		return ErrPackageUnknown
+	case strings.HasPrefix(firstLine, "run-as:") && strings.Contains(firstLine, RunAsPackageNotDebuggable):
+		return ErrPackageNotDebuggable
+	case strings.HasSuffix(firstLine, "No such file or directory"):
+		return util.Errorf(util.FileNoExistError, "%s", firstLine)
+	case strings.HasSuffix(firstLine, "Permission denied"):
+		return ErrNoPermission
+	case strings.HasSuffix(firstLine, "Not a directory"):
+		return syscall.ENOTDIR
+	case strings.HasSuffix(firstLine, "Is a directory"):
+		return syscall.EISDIR
+	case strings.HasSuffix(firstLine, "Directory not empty"):
+		return syscall.ENOTEMPTY
+	case strings.HasPrefix(firstLine, "run-as:"):
+		return util.Errorf(util.AdbError, "%s", firstLine)
+	}
+	return nil
+}
+
+// quoteShellArg quotes arg so it's interpreted as a single word by sh.
+// The result never contains double quotes, since goadb can't pass them to the device, so they're
+// printed by printf instead.
+func quoteShellArg(arg string) string {
+	return "'" + shellQuoteEscaper.Replace(arg) + "'"
+}
+
+var shellQuoteEscaper = strings.NewReplacer(`'`, `'\''`, `"`, `'$(printf '\042')'`)
+
+// Bits of st_mode, as defined by Linux.
+const (
+	unixModeTypeMask  = 0170000
+	unixModeSocket    = 0140000
+	unixModeSymlink   = 0120000
+	unixModeRegular   = 0100000
+	unixModeBlock     = 0060000
+	unixModeDir       = 0040000
+	unixModeCharacter = 0020000
+	unixModeFifo      = 0010000
+	unixModeSetuid    = 04000
+	unixModeSetgid    = 02000
+	unixModeSticky    = 01000
+)
+
+// unixModeToFileMode converts a raw st_mode value from the device to an os.FileMode.
+func unixModeToFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+
+	switch mode & unixModeTypeMask {
+	case unixModeSocket:
+		fileMode |= os.ModeSocket
+	case unixModeSymlink:
+		fileMode |= os.ModeSymlink
+	case unixModeBlock:
+		fileMode |= os.ModeDevice
+	case unixModeDir:
+		fileMode |= os.ModeDir
+	case unixModeCharacter:
+		fileMode |= os.ModeDevice | os.ModeCharDevice
+	case unixModeFifo:
+		fileMode |= os.ModeNamedPipe
+	}
+
+	if mode&unixModeSetuid != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&unixModeSetgid != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&unixModeSticky != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
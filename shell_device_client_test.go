@@ -0,0 +1,193 @@
+package adbfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+func TestShellDeviceClient_RunCommandWrapped(t *testing.T) {
+	var gotCmd string
+	var gotArgs []string
+	client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+		gotCmd, gotArgs = cmd, args
+		return "", nil
+	})
+
+	_, err := client.RunCommand("mkdir", "/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "run-as", gotCmd)
+	assert.Equal(t, []string{"com.example", "mkdir", "/foo"}, gotArgs)
+}
+
+func TestShellDeviceClient_Stat(t *testing.T) {
+	client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+		assert.Equal(t, []string{"com.example", "sh", "-c", "stat -c '%f:%s:%Y:%n' '/data/data/com.example/foo'"}, args)
+		return "81a4:42:1000:/data/data/com.example/foo\r\n", nil
+	})
+
+	entry, err := client.Stat("/data/data/com.example/foo", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/data/com.example/foo", entry.Name)
+	assert.Equal(t, os.FileMode(0644), entry.Mode)
+	assert.Equal(t, int32(42), entry.Size)
+	assert.Equal(t, time.Unix(1000, 0), entry.ModifiedAt)
+}
+
+func TestShellDeviceClient_StatSpecialCharacters(t *testing.T) {
+	var commands []string
+	client := newTestRunAsClient(recordCommands(&commands, func(cmd string, args []string) (string, error) {
+		return "81a4:42:1000:/data/$HOME`id`\"it's\"\n", nil
+	}))
+
+	entry, err := client.Stat("/data/$HOME`id`\"it's\"", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/$HOME`id`\"it's\"", entry.Name)
+	// goadb double-quotes the script, so the device shell would expand $ and ` in it, and can't pass
+	// double quotes at all.
+	assert.Equal(t, []string{
+		"run-as com.example sh -c stat -c '%f:%s:%Y:%n' '/data/\\$HOME\\`id\\`'\\$(printf '\\\\042')'it'\\\\''s'\\$(printf '\\\\042')''",
+	}, commands)
+}
+
+func TestShellDeviceClient_StatErrors(t *testing.T) {
+	for _, test := range []struct {
+		Output string
+		Errno  syscall.Errno
+	}{
+		{"stat: '/foo': No such file or directory", syscall.ENOENT},
+		{"stat: '/foo': Permission denied", syscall.EACCES},
+		{"run-as: Package 'com.example' is unknown", syscall.ENOENT},
+		{"run-as: Package 'com.example' is not debuggable", syscall.EPERM},
+		{"run-as: package not debuggable: com.example", syscall.EPERM},
+		{"run-as: Could not set capabilities: Operation not permitted", syscall.EIO},
+	} {
+		client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+			return test.Output + "\n", nil
+		})
+
+		_, err := client.Stat("/foo", &LogEntry{})
+		assert.Equal(t, test.Errno, toErrno(err), "%s", test.Output)
+	}
+}
+
+func TestShellDeviceClient_ListDirEntries(t *testing.T) {
+	var commands []string
+	client := newTestRunAsClient(recordCommands(&commands, func(cmd string, args []string) (string, error) {
+		return "41f9:4096:1:.\n" +
+			"41f9:4096:1:..\n" +
+			"41f9:4096:1:files\n" +
+			"81b0:5:2:foo bar.txt\n", nil
+	}))
+
+	entries, err := client.ListDirEntries("/data/it's", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "files", entries[0].Name)
+	assert.True(t, entries[0].Mode.IsDir())
+	assert.Equal(t, "foo bar.txt", entries[1].Name)
+	assert.True(t, entries[1].Mode.IsRegular())
+	assert.Equal(t, []string{
+		`run-as com.example sh -c cd '/data/it'\\''s' && stat -c '%f:%s:%Y:%n' .* * 2>/dev/null`,
+	}, commands)
+}
+
+func TestShellDeviceClient_ListDirEntriesEmpty(t *testing.T) {
+	client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+		return "41f9:4096:1:.\n41f9:4096:1:..\n", nil
+	})
+
+	entries, err := client.ListDirEntries("/data", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestShellDeviceClient_ListDirEntriesNoExist(t *testing.T) {
+	client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+		return "/system/bin/sh: cd: /data/foo: No such file or directory\n", nil
+	})
+
+	_, err := client.ListDirEntries("/data/foo", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+}
+
+func TestShellDeviceClient_OpenRead(t *testing.T) {
+	var commands []string
+	client := newTestRunAsClient(recordCommands(&commands, func(cmd string, args []string) (string, error) {
+		if strings.HasPrefix(args[3], "stat ") {
+			return "81a4:5:1:/foo`id`", nil
+		}
+		return "hello", nil
+	}))
+
+	r, err := client.OpenRead("/foo`id`", &LogEntry{})
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "run-as com.example sh -c cat '/foo\\`id\\`'", commands[1])
+}
+
+func TestShellDeviceClient_OpenReadNoExist(t *testing.T) {
+	client := newTestRunAsClient(func(cmd string, args []string) (string, error) {
+		if strings.HasPrefix(args[3], "stat ") {
+			return "stat: '/foo': No such file or directory", nil
+		}
+		t.Fatal("invalid command:", cmd, args)
+		return "", nil
+	})
+
+	_, err := client.OpenRead("/foo", &LogEntry{})
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+}
+
+func TestShellDeviceClient_OpenWrite(t *testing.T) {
+	var pushed bytes.Buffer
+	var commands []string
+	client := newTestRunAsClient(recordCommands(&commands, nil))
+	client.DeviceClient.(*delegateDeviceClient).openWrite = openWriteTo(&pushed)
+
+	w, err := client.OpenWrite("/data/it's", 0660, time.Time{}, &LogEntry{})
+	assert.NoError(t, err)
+	w.Write([]byte("hello"))
+	assert.NoError(t, w.Close())
+
+	assert.Equal(t, "hello", pushed.String())
+	assert.Len(t, commands, 2)
+	assert.Contains(t, commands[0], "run-as com.example sh -c cat '/data/local/tmp/adbfs-")
+	assert.Contains(t, commands[0], `> '/data/it'\\''s' && chmod 660 '/data/it'\\''s'`)
+	assert.True(t, strings.HasPrefix(commands[1], "rm -f /data/local/tmp/adbfs-"), commands[1])
+}
+
+func TestShellDeviceClient_OpenWriteSpecialCharacters(t *testing.T) {
+	var commands []string
+	client := newTestRunAsClient(recordCommands(&commands, nil))
+	client.DeviceClient.(*delegateDeviceClient).openWrite = openWriteNoop()
+
+	w, err := client.OpenWrite("/data/$HOME`id`.txt", 0660, time.Time{}, &LogEntry{})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	// goadb double-quotes the script, so the device shell would expand $ and ` in it.
+	assert.Contains(t, commands[0], "> '/data/\\$HOME\\`id\\`.txt' && chmod 660 '/data/\\$HOME\\`id\\`.txt'")
+}
+
+func TestUnixModeToFileMode(t *testing.T) {
+	assert.Equal(t, os.ModeDir|0755, unixModeToFileMode(040755))
+	assert.Equal(t, os.FileMode(0644), unixModeToFileMode(0100644))
+	assert.Equal(t, os.ModeSymlink|0777, unixModeToFileMode(0120777))
+	assert.Equal(t, os.ModeNamedPipe|0600, unixModeToFileMode(010600))
+	assert.Equal(t, os.ModeDir|os.ModeSticky|0771, unixModeToFileMode(041771))
+}
+
+func newTestRunAsClient(runCommand func(cmd string, args []string) (string, error)) *ShellDeviceClient {
+	return NewRunAsDeviceClientFactory("com.example", func() DeviceClient {
+		return &delegateDeviceClient{runCommand: runCommand}
+	})().(*ShellDeviceClient)
+}
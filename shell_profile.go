@@ -0,0 +1,129 @@
+package adbfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ShellImplementation identifies the multi-call binary that provides a shell command.
+type ShellImplementation int
+
+const (
+	// The command is a standalone binary, or its implementation couldn't be determined.
+	ShellStandalone ShellImplementation = iota
+	// Toolbox, used by Android before Marshmallow.
+	ShellToolbox
+	// Toybox, used by Android Marshmallow and later.
+	ShellToybox
+	// Busybox, installed by some ROMs.
+	ShellBusybox
+)
+
+func (i ShellImplementation) String() string {
+	switch i {
+	case ShellStandalone:
+		return "standalone"
+	case ShellToolbox:
+		return "toolbox"
+	case ShellToybox:
+		return "toybox"
+	case ShellBusybox:
+		return "busybox"
+	}
+	return "unknown"
+}
+
+// Commands run by helpers that depend on the shell implementation. Others, like mkdir and rm,
+// aren't probed since helpers only check whether they print anything, which is the same everywhere,
+// and parseDf understands every implementation's df.
+var probedApplets = []string{"readlink", "stat"}
+
+/*
+ShellProfile describes the shell commands available on a device.
+
+Probe it once with ProbeShellProfile, then use NewShellAdapterDeviceClientFactory so commands run
+by helpers like readLink and statfs are adapted to the device's implementations.
+*/
+type ShellProfile struct {
+	// The implementation of every probed command that exists on the device.
+	Applets map[string]ShellImplementation
+
+	// stat supports -f to report filesystem stats.
+	StatFilesystem bool
+
+	// readlink supports -f to resolve links recursively.
+	ReadlinkCanonicalize bool
+}
+
+// ProbeShellProfile detects which implementation provides each command, and which flags they
+// support, with a single command.
+func ProbeShellProfile(client DeviceClient) (*ShellProfile, error) {
+	var script bytes.Buffer
+	fmt.Fprintf(&script, "for a in %s; do p=$(command -v $a) && echo applet $a $p $(readlink $p 2>/dev/null); done; ",
+		strings.Join(probedApplets, " "))
+	script.WriteString("stat -f / >/dev/null 2>&1 && echo flag stat-f; ")
+	script.WriteString("readlink -f / >/dev/null 2>&1 && echo flag readlink-f")
+
+	output, err := client.RunCommand("sh", "-c", doubleQuoteEscaper.Replace(script.String()))
+	if err != nil {
+		return nil, err
+	}
+	return parseShellProfile(output), nil
+}
+
+func parseShellProfile(output string) *ShellProfile {
+	profile := &ShellProfile{
+		Applets: make(map[string]ShellImplementation),
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 3 && fields[0] == "applet":
+			// The binary is either linked to the multi-call binary, or is it.
+			binary := fields[2]
+			if len(fields) >= 4 {
+				binary = fields[3]
+			}
+			profile.Applets[fields[1]] = shellImplementationOf(binary)
+		case len(fields) == 2 && fields[0] == "flag":
+			switch fields[1] {
+			case "stat-f":
+				profile.StatFilesystem = true
+			case "readlink-f":
+				profile.ReadlinkCanonicalize = true
+			}
+		}
+	}
+	return profile
+}
+
+func shellImplementationOf(binary string) ShellImplementation {
+	switch path.Base(binary) {
+	case "toolbox":
+		return ShellToolbox
+	case "toybox":
+		return ShellToybox
+	case "busybox":
+		return ShellBusybox
+	}
+	return ShellStandalone
+}
+
+func (p *ShellProfile) String() string {
+	var buf bytes.Buffer
+	for _, applet := range probedApplets {
+		if impl, ok := p.Applets[applet]; ok {
+			fmt.Fprintf(&buf, "%s: %s\n", applet, impl)
+		} else {
+			fmt.Fprintf(&buf, "%s: missing\n", applet)
+		}
+	}
+	fmt.Fprintf(&buf, "stat -f: %t\n", p.StatFilesystem)
+	fmt.Fprintf(&buf, "readlink -f: %t\n", p.ReadlinkCanonicalize)
+	return buf.String()
+}
@@ -0,0 +1,42 @@
+package adbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeShellProfile(t *testing.T) {
+	var command string
+	client := &delegateDeviceClient{
+		runCommand: func(cmd string, args []string) (string, error) {
+			command = cmd + " " + strings.Join(args, " ")
+			return "applet readlink /system/xbin/readlink /system/xbin/busybox\r\n" +
+				"applet stat /system/bin/stat toybox\r\n" +
+				"flag stat-f\r\n", nil
+		},
+	}
+
+	profile, err := ProbeShellProfile(client)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(command, "sh -c for a in readlink stat; do"), command)
+	assert.Equal(t, map[string]ShellImplementation{
+		"readlink": ShellBusybox,
+		"stat":     ShellToybox,
+	}, profile.Applets)
+	assert.True(t, profile.StatFilesystem)
+	assert.False(t, profile.ReadlinkCanonicalize)
+
+	assert.Equal(t, `readlink: busybox
+stat: toybox
+stat -f: true
+readlink -f: false
+`, profile.String())
+}
+
+func TestParseShellProfile_Empty(t *testing.T) {
+	profile := parseShellProfile("sh: command: not found\n")
+	assert.Empty(t, profile.Applets)
+	assert.False(t, profile.StatFilesystem)
+}
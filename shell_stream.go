@@ -0,0 +1,83 @@
+package adbfs
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+// ShellStreamOpener runs a shell command on a device and returns a stream of its output that can
+// be read as it's produced. Closing the stream stops the command.
+type ShellStreamOpener func(command string) (io.ReadCloser, error)
+
+/*
+NewAdbShellStreamOpener returns a ShellStreamOpener that runs commands on the device with serial,
+using the adb server listening on address (e.g. "localhost:5037").
+
+goadb only supports running commands to completion, so this talks to the adb server directly.
+See https://android.googlesource.com/platform/system/core/+/master/adb/SERVICES.TXT.
+*/
+func NewAdbShellStreamOpener(address, serial string) ShellStreamOpener {
+	return func(command string) (io.ReadCloser, error) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, util.WrapErrorf(err, util.ServerNotAvailable, "error connecting to adb server at %s", address)
+		}
+
+		if err := sendAdbRequest(conn, "host:transport:"+serial); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := sendAdbRequest(conn, "shell:"+command); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		// Everything after the OKAY is the output of the command.
+		return conn, nil
+	}
+}
+
+// sendAdbRequest sends a length-prefixed request to the adb server and reads the status response.
+func sendAdbRequest(conn io.ReadWriter, request string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(request), request); err != nil {
+		return util.WrapErrorf(err, util.NetworkError, "error sending request '%s'", request)
+	}
+
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return util.WrapErrorf(err, util.NetworkError, "error reading status for '%s'", request)
+	}
+
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := readAdbMessage(conn)
+		if err != nil {
+			return util.WrapErrorf(err, util.NetworkError, "error reading failure message for '%s'", request)
+		}
+		return util.Errorf(util.AdbError, "request '%s' failed: %s", request, message)
+	}
+	return util.Errorf(util.AssertionError, "invalid status for '%s': %q", request, status)
+}
+
+func readAdbMessage(r io.Reader) (string, error) {
+	lengthHex := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthHex); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(lengthHex), 16, 16)
+	if err != nil {
+		return "", err
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
@@ -0,0 +1,66 @@
+package adbfs
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+func TestAdbShellStreamOpener(t *testing.T) {
+	var requests []string
+	address := serveFakeAdb(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		requests = append(requests, readFakeAdbRequest(r))
+		conn.Write([]byte("OKAY"))
+		requests = append(requests, readFakeAdbRequest(r))
+		conn.Write([]byte("OKAYline 1\nline 2\n"))
+		conn.Close()
+	})
+
+	stream, err := NewAdbShellStreamOpener(address, "abc")("logcat -b crash")
+	assert.NoError(t, err)
+	output, _ := ioutil.ReadAll(stream)
+	stream.Close()
+
+	assert.Equal(t, "line 1\nline 2\n", string(output))
+	assert.Equal(t, []string{"host:transport:abc", "shell:logcat -b crash"}, requests)
+}
+
+func TestAdbShellStreamOpener_Fail(t *testing.T) {
+	address := serveFakeAdb(t, func(conn net.Conn) {
+		readFakeAdbRequest(bufio.NewReader(conn))
+		conn.Write([]byte("FAIL0010device not found"))
+		conn.Close()
+	})
+
+	_, err := NewAdbShellStreamOpener(address, "abc")("logcat")
+	assert.True(t, util.HasErrCode(err, util.AdbError))
+	assert.Contains(t, err.Error(), "device not found")
+}
+
+// serveFakeAdb handles a single connection with handler, and returns the address to connect to.
+func serveFakeAdb(t *testing.T, handler func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handler(conn)
+	}()
+	return listener.Addr().String()
+}
+
+func readFakeAdbRequest(r io.Reader) string {
+	message, _ := readAdbMessage(r)
+	return message
+}
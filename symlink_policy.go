@@ -0,0 +1,139 @@
+package adbfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+// SymlinkPolicy controls how symlinks whose targets are outside the device root are presented.
+// Links that point inside the device root are always rewritten to point inside the mountpoint.
+type SymlinkPolicy int
+
+const (
+	// Rewrite targets to point inside the mountpoint where possible, by resolving them until they
+	// end up inside the device root. Links that never do are rewritten as if the device root
+	// was /, like links inside the device root.
+	SymlinkRewrite SymlinkPolicy = iota
+	// Present escaping links as the files or directories they resolve to.
+	SymlinkFollow
+	// Hide escaping links, so they don't exist as far as the mount is concerned.
+	SymlinkHide
+)
+
+func ParseSymlinkPolicy(policy string) (SymlinkPolicy, error) {
+	for _, p := range []SymlinkPolicy{SymlinkRewrite, SymlinkFollow, SymlinkHide} {
+		if p.String() == policy {
+			return p, nil
+		}
+	}
+	return SymlinkRewrite, fmt.Errorf("invalid symlink policy: %s", policy)
+}
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkRewrite:
+		return "rewrite"
+	case SymlinkFollow:
+		return "follow"
+	case SymlinkHide:
+		return "hide"
+	}
+	return "unknown"
+}
+
+// mountRelativePath returns the path relative to the device root of devicePath, an absolute path
+// on the device, if it's inside the device root. Both the configured root and the root it resolves
+// to are checked.
+func (fs *AdbFileSystem) mountRelativePath(devicePath string) (string, bool) {
+	devicePath = strings.Trim(path.Clean(devicePath), "/")
+	for _, root := range []string{fs.config.DeviceRoot, fs.unresolvedDeviceRoot} {
+		if rel, ok := relativePath(strings.Trim(path.Clean("/"+root), "/"), devicePath); ok {
+			return rel, true
+		}
+	}
+	return "", false
+}
+
+// translateLinkTarget converts target, the target of the link at name, to a path on the host.
+// Returns ENOENT if the link escapes the device root and the policy is SymlinkHide.
+func (fs *AdbFileSystem) translateLinkTarget(client DeviceClient, name, target string, logEntry *LogEntry) (string, error) {
+	if strings.HasPrefix(target, "/") {
+		if rel, ok := fs.mountRelativePath(target); ok {
+			return path.Join(fs.config.Mountpoint, rel), nil
+		}
+	} else if _, ok := fs.mountRelativePath(path.Join(path.Dir(name), target)); ok {
+		// Relative links work as-is.
+		return target, nil
+	}
+
+	resolved, _, escapes := fs.resolveOutsideLink(client, name, logEntry)
+	if !escapes {
+		rel, _ := fs.mountRelativePath(resolved)
+		return path.Join(fs.config.Mountpoint, rel), nil
+	}
+
+	if fs.config.SymlinkPolicy == SymlinkHide {
+		return "", util.Errorf(util.FileNoExistError, "%s escapes the device root", name)
+	}
+	if strings.HasPrefix(target, "/") {
+		return path.Join(fs.config.Mountpoint, target), nil
+	}
+	return target, nil
+}
+
+// resolveOutsideLink resolves the link at name, whose target is outside the device root.
+// The link escapes the device root unless its target is a link back into it, e.g. /sdcard/foo when
+// the device root is /storage/emulated/0, in which case resolved is the path it leads to.
+// Broken links escape, with a nil entry. translateLinkTarget and applySymlinkPolicy must make the
+// same decision, or links would be listed but not readable.
+func (fs *AdbFileSystem) resolveOutsideLink(client DeviceClient, name string, logEntry *LogEntry) (resolved string, entry *adb.DirEntry, escapes bool) {
+	resolved, entry, err := readLinkRecursively(client, name, logEntry)
+	if err != nil {
+		return "", nil, true
+	}
+	if _, ok := fs.mountRelativePath(resolved); ok {
+		return resolved, entry, false
+	}
+	return resolved, entry, true
+}
+
+// applySymlinkPolicy returns the entry to present for entry, the result of stating name.
+// If entry is a link that escapes the device root, it returns the entry of the link's target if
+// the policy is SymlinkFollow, or nil if the policy is SymlinkHide. Broken links can't be followed,
+// so they're returned as-is by SymlinkFollow.
+func (fs *AdbFileSystem) applySymlinkPolicy(client DeviceClient, name string, entry *adb.DirEntry, logEntry *LogEntry) *adb.DirEntry {
+	if fs.config.SymlinkPolicy == SymlinkRewrite || entry.Mode&os.ModeSymlink == 0 {
+		return entry
+	}
+
+	target, err := readLink(client, name, logEntry)
+	if err != nil {
+		return entry
+	}
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(path.Dir(name), target)
+	}
+	if _, ok := fs.mountRelativePath(target); ok {
+		return entry
+	}
+
+	_, resolvedEntry, escapes := fs.resolveOutsideLink(client, name, logEntry)
+	if !escapes {
+		return entry
+	}
+
+	if fs.config.SymlinkPolicy == SymlinkHide {
+		return nil
+	}
+	if resolvedEntry == nil {
+		return entry
+	}
+	followed := *resolvedEntry
+	followed.Name = entry.Name
+	return &followed
+}
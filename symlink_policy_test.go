@@ -0,0 +1,165 @@
+package adbfs
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+// newSymlinkTestFileSystem mounts /sdcard, which is a link to /storage/emulated/0, and contains
+// links that point inside and outside of it.
+func newSymlinkTestFileSystem(t *testing.T, policy SymlinkPolicy) *AdbFileSystem {
+	links := map[string]string{
+		"/sdcard":                    "/storage/emulated/0",
+		"/storage/emulated/0/inside": "/sdcard/Music",
+		"/storage/emulated/0/escape": "/system/etc",
+		"/storage/emulated/0/hosts":  "../../../system/etc/hosts",
+		"/storage/emulated/0/broken": "/system/missing",
+	}
+	entries := map[string]*adb.DirEntry{
+		"/storage/emulated/0":       {Name: "0", Mode: os.ModeDir},
+		"/storage/emulated/0/Music": {Name: "Music", Mode: os.ModeDir},
+		"/system/etc":               {Name: "etc", Mode: os.ModeDir},
+		"/system/etc/hosts":         {Name: "hosts", Size: 42},
+	}
+	for name := range links {
+		entries[name] = &adb.DirEntry{Name: path.Base(name), Mode: os.ModeSymlink}
+	}
+
+	dev := &delegateDeviceClient{
+		stat: func(name string) (*adb.DirEntry, error) {
+			if entry, ok := entries[name]; ok {
+				return entry, nil
+			}
+			return nil, util.Errorf(util.FileNoExistError, "%s", name)
+		},
+		listDirEntries: func(name string) ([]*adb.DirEntry, error) {
+			if name != "/storage/emulated/0" {
+				t.Fatal("invalid dir:", name)
+			}
+			return []*adb.DirEntry{
+				entries["/storage/emulated/0/Music"],
+				entries["/storage/emulated/0/inside"],
+				entries["/storage/emulated/0/escape"],
+				entries["/storage/emulated/0/hosts"],
+				entries["/storage/emulated/0/broken"],
+			}, nil
+		},
+		runCommand: func(cmd string, args []string) (string, error) {
+			if target, ok := links[args[0]]; cmd == "readlink" && ok {
+				return target + "\r\n", nil
+			}
+			if cmd == "readlink" {
+				return ReadlinkInvalidArgument, nil
+			}
+			t.Fatal("invalid command:", cmd, args)
+			return "", nil
+		},
+	}
+	fs, err := NewAdbFileSystem(Config{
+		Mountpoint:    "/mnt",
+		ClientFactory: func() DeviceClient { return dev },
+		DeviceRoot:    "/sdcard",
+		SymlinkPolicy: policy,
+	})
+	assert.NoError(t, err)
+	return fs.(*AdbFileSystem)
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, policy := range []SymlinkPolicy{SymlinkRewrite, SymlinkFollow, SymlinkHide} {
+		parsed, err := ParseSymlinkPolicy(policy.String())
+		assert.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseSymlinkPolicy("foo")
+	assert.Error(t, err)
+}
+
+func TestSymlinkPolicy_Rewrite(t *testing.T) {
+	fs := newSymlinkTestFileSystem(t, SymlinkRewrite)
+
+	target, status := fs.Readlink("inside", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, "/mnt/Music", target)
+
+	target, status = fs.Readlink("escape", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, "/mnt/system/etc", target)
+
+	target, status = fs.Readlink("hosts", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, "../../../system/etc/hosts", target)
+
+	attr, status := fs.GetAttr("escape", newContext())
+	assertStatusOk(t, status)
+	assert.True(t, attr.IsSymlink())
+}
+
+func TestSymlinkPolicy_Follow(t *testing.T) {
+	fs := newSymlinkTestFileSystem(t, SymlinkFollow)
+
+	attr, status := fs.GetAttr("escape", newContext())
+	assertStatusOk(t, status)
+	assert.True(t, attr.IsDir())
+
+	attr, status = fs.GetAttr("hosts", newContext())
+	assertStatusOk(t, status)
+	assert.True(t, attr.IsRegular())
+	assert.Equal(t, uint64(42), attr.Size)
+
+	attr, status = fs.GetAttr("inside", newContext())
+	assertStatusOk(t, status)
+	assert.True(t, attr.IsSymlink())
+
+	entries, status := fs.OpenDir("", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "Music", Mode: fuse.S_IFDIR},
+		{Name: "inside", Mode: fuse.S_IFLNK},
+		{Name: "escape", Mode: fuse.S_IFDIR},
+		{Name: "hosts", Mode: fuse.S_IFREG},
+		{Name: "broken", Mode: fuse.S_IFLNK},
+	}, entries)
+}
+
+func TestSymlinkPolicy_Hide(t *testing.T) {
+	fs := newSymlinkTestFileSystem(t, SymlinkHide)
+
+	_, status := fs.GetAttr("escape", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+	_, status = fs.Readlink("escape", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+
+	// Broken links can't lead back inside, so they're hidden too.
+	_, status = fs.GetAttr("broken", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+	_, status = fs.Readlink("broken", newContext())
+	assert.Equal(t, fuse.ENOENT, status)
+
+	target, status := fs.Readlink("inside", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, "/mnt/Music", target)
+
+	entries, status := fs.OpenDir("", newContext())
+	assertStatusOk(t, status)
+	assert.Equal(t, []fuse.DirEntry{
+		{Name: "Music", Mode: fuse.S_IFDIR},
+		{Name: "inside", Mode: fuse.S_IFLNK},
+	}, entries)
+}
+
+func TestReadLinkRecursively_RelativeTarget(t *testing.T) {
+	fs := newSymlinkTestFileSystem(t, SymlinkRewrite)
+
+	target, entry, err := readLinkRecursively(fs.getQuickUseClient(), "/storage/emulated/0/hosts", &LogEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, "/system/etc/hosts", target)
+	assert.Equal(t, int32(42), entry.Size)
+}
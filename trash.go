@@ -0,0 +1,180 @@
+package adbfs
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zach-klippenstein/adbfs/internal/cli"
+	. "github.com/zach-klippenstein/adbfs/internal/util"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+const (
+	// Name of the trash directory created in the device root.
+	TrashDirName = ".Trash-adbfs"
+
+	// Expired entries are purged at most this often.
+	TrashPurgeInterval = time.Hour
+
+	trashInfoSuffix     = ".trashinfo"
+	trashInfoDateFormat = "2006-01-02T15:04:05"
+)
+
+/*
+Trash moves deleted files into a directory on the device instead of removing them.
+
+The directory follows the layout of the freedesktop.org trash spec, so deleted files are in
+files/, and info/ contains a .trashinfo file for each one that records its original path and
+when it was deleted. Entries older than Expiry are purged periodically.
+*/
+type Trash struct {
+	// Directory on the device that files are deleted from.
+	Root string
+
+	// How long to keep deleted files. If 0, they are kept until the trash is emptied.
+	Expiry time.Duration
+
+	Clock Clock
+
+	lock      sync.Mutex
+	lastPurge time.Time
+}
+
+func NewTrash(deviceRoot string, expiry time.Duration) *Trash {
+	return &Trash{
+		Root:   deviceRoot,
+		Expiry: expiry,
+		Clock:  SystemClock,
+	}
+}
+
+func (t *Trash) Dir() string {
+	return path.Join("/", t.Root, TrashDirName)
+}
+
+// Contains returns true if relPath, a path relative to Root, is the trash directory or inside it.
+func (t *Trash) Contains(relPath string) bool {
+	relPath = strings.Trim(relPath, "/")
+	return relPath == TrashDirName || strings.HasPrefix(relPath, TrashDirName+"/")
+}
+
+// Move moves the file at name, a path on the device, to the trash. relPath is the path of the file
+// relative to Root, and is recorded so the file can be restored.
+func (t *Trash) Move(client DeviceClient, name, relPath string) error {
+	return t.move(client, name, relPath, func(src, dst string) string {
+		return fmt.Sprintf("mv %s %s", src, dst)
+	})
+}
+
+// MoveDir moves the empty directory at name, a path on the device, to the trash, like Move.
+// Directories that aren't empty aren't moved, and return ENOTEMPTY like rmdir. Since the directory
+// is empty, it's removed and recreated in the trash, which leaves rmdir to check that it's empty.
+func (t *Trash) MoveDir(client DeviceClient, name, relPath string) error {
+	return t.move(client, name, relPath, func(src, dst string) string {
+		return fmt.Sprintf("rmdir %s && mkdir %s", src, dst)
+	})
+}
+
+// move records the info file for name, then runs the command returned by moveCommand to move it
+// from src to dst, which are already quoted.
+func (t *Trash) move(client DeviceClient, name, relPath string, moveCommand func(src, dst string) string) error {
+	now := t.Clock.Now()
+	trashName := fmt.Sprintf("%d-%s", now.UnixNano(), path.Base(name))
+	filesDir := path.Join(t.Dir(), "files")
+	infoDir := path.Join(t.Dir(), "info")
+	infoPath := path.Join(infoDir, trashName+trashInfoSuffix)
+
+	// The spec allows paths relative to the directory containing the trash.
+	relPath = strings.Trim(relPath, "/")
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: relPath}).EscapedPath(), now.Format(trashInfoDateFormat))
+
+	// Do everything in one command to avoid extra round trips, and remove the info file if the
+	// move fails.
+	script := fmt.Sprintf("mkdir -p %s %s && printf %%s %s > %s && { %s || rm -f %s; }",
+		quoteShellArg(filesDir), quoteShellArg(infoDir),
+		quoteShellArg(info), quoteShellArg(infoPath),
+		moveCommand(quoteShellArg(name), quoteShellArg(path.Join(filesDir, trashName))), quoteShellArg(infoPath))
+	output, err := client.RunCommand("sh", "-c", doubleQuoteEscaper.Replace(script))
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		if err := parseShellError(output); err != nil {
+			return err
+		}
+		// TODO Be smarter about this error.
+		return ErrNoPermission
+	}
+
+	t.maybePurge(client)
+	return nil
+}
+
+// Empty permanently deletes everything in the trash.
+func (t *Trash) Empty(client DeviceClient) error {
+	output, err := client.RunScheduledCommand(CommandOperation, nil, "rm", "-rf", t.Dir())
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		return util.Errorf(util.AdbError, "error emptying trash %s: %s", t.Dir(), strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// Purge permanently deletes every entry that was deleted more than Expiry ago.
+func (t *Trash) Purge(client DeviceClient) error {
+	if t.Expiry <= 0 {
+		return nil
+	}
+
+	infoDir := path.Join(t.Dir(), "info")
+	infos, err := client.ListDirEntries(infoDir, &LogEntry{})
+	if util.HasErrCode(err, util.FileNoExistError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Info files are written when entries are deleted, so their mtime is the deletion time.
+	cutoff := t.Clock.Now().Add(-t.Expiry)
+	var expired []string
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name, trashInfoSuffix) || !info.ModifiedAt.Before(cutoff) {
+			continue
+		}
+		trashName := strings.TrimSuffix(info.Name, trashInfoSuffix)
+		expired = append(expired,
+			path.Join(t.Dir(), "files", trashName),
+			path.Join(infoDir, info.Name))
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	cli.Log.Infof("purging %d expired entries from %s", len(expired)/2, t.Dir())
+	_, err = client.RunScheduledCommand(CommandOperation, nil, "rm", append([]string{"-rf"}, expired...)...)
+	return err
+}
+
+// maybePurge purges the trash if it hasn't been purged in the last TrashPurgeInterval.
+func (t *Trash) maybePurge(client DeviceClient) {
+	t.lock.Lock()
+	now := t.Clock.Now()
+	shouldPurge := now.Sub(t.lastPurge) >= TrashPurgeInterval
+	if shouldPurge {
+		t.lastPurge = now
+	}
+	t.lock.Unlock()
+
+	if shouldPurge {
+		if err := t.Purge(client); err != nil {
+			cli.Log.Warnf("error purging trash %s: %s", t.Dir(), err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package adbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/stretchr/testify/assert"
+	. "github.com/zach-klippenstein/adbfs/internal/util"
+	"github.com/zach-klippenstein/goadb"
+	"github.com/zach-klippenstein/goadb/util"
+)
+
+func TestTrash_Contains(t *testing.T) {
+	trash := NewTrash("/sdcard", 0)
+	assert.True(t, trash.Contains(".Trash-adbfs"))
+	assert.True(t, trash.Contains("/.Trash-adbfs/files/foo"))
+	assert.False(t, trash.Contains(".Trash-adbfsx"))
+	assert.False(t, trash.Contains("foo/.Trash-adbfs"))
+}
+
+func TestTrash_Move(t *testing.T) {
+	TestClock.Reset()
+	var script string
+	client := &delegateDeviceClient{
+		runCommand: func(cmd string, args []string) (string, error) {
+			assert.Equal(t, "sh", cmd)
+			script = args[1]
+			return "", nil
+		},
+	}
+	trash := NewTrash("/sdcard", 0)
+	trash.Clock = &TestClock
+
+	assert.NoError(t, trash.Move(client, "/storage/emulated/0/a b/it's.txt", "a b/it's.txt"))
+	assert.Equal(t, `mkdir -p '/sdcard/.Trash-adbfs/files' '/sdcard/.Trash-adbfs/info' && `+
+		`printf %s '[Trash Info]
+Path=a%20b/it%27s.txt
+DeletionDate=`+time.Unix(1, 0).Format(trashInfoDateFormat)+`
+' > '/sdcard/.Trash-adbfs/info/1000000000-it'\\''s.txt.trashinfo' && `+
+		`{ mv '/storage/emulated/0/a b/it'\\''s.txt' '/sdcard/.Trash-adbfs/files/1000000000-it'\\''s.txt' || `+
+		`rm -f '/sdcard/.Trash-adbfs/info/1000000000-it'\\''s.txt.trashinfo'; }`, script)
+}
+
+func TestTrash_MoveError(t *testing.T) {
+	client := &delegateDeviceClient{
+		runCommand: func(cmd string, args []string) (string, error) {
+			return "mv: /sdcard/foo: No such file or directory\n", nil
+		},
+	}
+	err := NewTrash("/sdcard", 0).Move(client, "/sdcard/foo", "foo")
+	assert.True(t, util.HasErrCode(err, util.FileNoExistError))
+}
+
+func TestTrash_Purge(t *testing.T) {
+	TestClock.Reset()
+	TestClock.Advance(48 * time.Hour)
+	var removed []string
+	client := &delegateDeviceClient{
+		listDirEntries: func(path string) ([]*adb.DirEntry, error) {
+			assert.Equal(t, "/sdcard/.Trash-adbfs/info", path)
+			return []*adb.DirEntry{
+				{Name: "1-old.txt.trashinfo", ModifiedAt: time.Unix(1, 0)},
+				{Name: "2-new.txt.trashinfo", ModifiedAt: TestClock.Now().Add(-time.Hour)},
+				{Name: "stray", ModifiedAt: time.Unix(1, 0)},
+			}, nil
+		},
+		runCommand: recordCommands(&removed, nil),
+	}
+	trash := NewTrash("/sdcard", 24*time.Hour)
+	trash.Clock = &TestClock
+
+	assert.NoError(t, trash.Purge(client))
+	assert.Equal(t, []string{
+		"rm -rf /sdcard/.Trash-adbfs/files/1-old.txt /sdcard/.Trash-adbfs/info/1-old.txt.trashinfo",
+	}, removed)
+}
+
+func TestTrash_PurgeMissingDir(t *testing.T) {
+	client := &delegateDeviceClient{
+		listDirEntries: func(path string) ([]*adb.DirEntry, error) {
+			return nil, util.Errorf(util.FileNoExistError, "no such file")
+		},
+	}
+	assert.NoError(t, NewTrash("/sdcard", time.Hour).Purge(client))
+}
+
+func TestControlFileSystem_EmptyTrash(t *testing.T) {
+	var commands []string
+	fs := NewControlFileSystem(newRecordingFileSystem(), ControlConfig{
+		Trashes: []*Trash{NewTrash("/a", 0), NewTrash("/b", 0)},
+		ClientFactory: func() DeviceClient {
+			return &delegateDeviceClient{
+				runCommand: recordCommands(&commands, nil),
+			}
+		},
+	})
+
+	_, status := fs.Open(".adbfs/empty-trash", uint32(O_RDONLY), nil)
+	assert.Equal(t, fuse.EPERM, status)
+
+	file, status := fs.Open(".adbfs/empty-trash", uint32(O_WRONLY), nil)
+	assert.Equal(t, fuse.OK, status)
+	assert.Equal(t, fuse.OK, file.Flush())
+	assert.Empty(t, commands)
+
+	file.Write([]byte("\n"), 0)
+	assert.Equal(t, fuse.OK, file.Flush())
+	assert.Equal(t, []string{"rm -rf /a/.Trash-adbfs", "rm -rf /b/.Trash-adbfs"}, commands)
+}
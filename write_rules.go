@@ -0,0 +1,46 @@
+package adbfs
+
+// WriteRules restricts which paths on the device can be modified.
+// Each rule is a glob pattern, as accepted by matchGlob, and applies to the paths it matches and
+// everything inside them.
+type WriteRules struct {
+	// If not empty, only paths that match at least one of these patterns can be modified.
+	Allow []string
+
+	// Paths that match any of these patterns can't be modified, even if they're allowed.
+	Deny []string
+}
+
+func NewWriteRules(allow, deny []string) (WriteRules, error) {
+	for _, patterns := range [][]string{allow, deny} {
+		for _, pattern := range patterns {
+			if err := isValidGlob(pattern); err != nil {
+				return WriteRules{}, err
+			}
+		}
+	}
+	return WriteRules{
+		Allow: allow,
+		Deny:  deny,
+	}, nil
+}
+
+// Check returns ErrReadOnlyPath and the reason if name, an absolute path on the device, can't
+// be modified.
+func (r WriteRules) Check(name string) (reason string, err error) {
+	for _, pattern := range r.Deny {
+		if matchGlob(pattern, name) {
+			return "denied by " + pattern, ErrReadOnlyPath
+		}
+	}
+
+	if len(r.Allow) == 0 {
+		return "", nil
+	}
+	for _, pattern := range r.Allow {
+		if matchGlob(pattern, name) {
+			return "", nil
+		}
+	}
+	return "not allowed by any rule", ErrReadOnlyPath
+}
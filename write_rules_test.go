@@ -0,0 +1,48 @@
+package adbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRules_Empty(t *testing.T) {
+	rules, err := NewWriteRules(nil, nil)
+	assert.NoError(t, err)
+
+	_, err = rules.Check("/sdcard/file")
+	assert.NoError(t, err)
+}
+
+func TestWriteRules_Allow(t *testing.T) {
+	rules, err := NewWriteRules([]string{"/sdcard/Download", "/data/local/tmp"}, nil)
+	assert.NoError(t, err)
+
+	_, err = rules.Check("/sdcard/Download/file")
+	assert.NoError(t, err)
+	_, err = rules.Check("/data/local/tmp")
+	assert.NoError(t, err)
+
+	reason, err := rules.Check("/sdcard/DCIM/photo.jpg")
+	assert.Equal(t, ErrReadOnlyPath, err)
+	assert.Equal(t, "not allowed by any rule", reason)
+}
+
+func TestWriteRules_DenyOverridesAllow(t *testing.T) {
+	rules, err := NewWriteRules([]string{"/sdcard"}, []string{"/sdcard/**/*.db"})
+	assert.NoError(t, err)
+
+	_, err = rules.Check("/sdcard/file")
+	assert.NoError(t, err)
+
+	reason, err := rules.Check("/sdcard/app/data.db")
+	assert.Equal(t, ErrReadOnlyPath, err)
+	assert.Equal(t, "denied by /sdcard/**/*.db", reason)
+}
+
+func TestWriteRules_Invalid(t *testing.T) {
+	_, err := NewWriteRules([]string{"sdcard"}, nil)
+	assert.Error(t, err)
+	_, err = NewWriteRules(nil, []string{"/[a"})
+	assert.Error(t, err)
+}